@@ -0,0 +1,108 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"plugin"
+	"time"
+)
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "bus: "+format+"\n", args...)
+	os.Exit(2)
+}
+
+func main() {
+	p, err := plugin.Open("plugin.so")
+	if err != nil {
+		fatalf("%v", err)
+	}
+	sym, err := p.Lookup("Next")
+	if err != nil {
+		fatalf("%v", err)
+	}
+	next := sym.(func() (interface{}, bool))
+	ctx := context.Background()
+
+	if err := plugin.Publish(ctx, "events", "hello"); err != nil {
+		fatalf("Publish: %v", err)
+	}
+	if v, ok := next(); !ok || v != "hello" {
+		fatalf("plugin received %v, %v, want hello", v, ok)
+	}
+
+	// The plugin's buffer holds one message; the second must wait.
+	if err := plugin.Publish(ctx, "events", "one"); err != nil {
+		fatalf("Publish: %v", err)
+	}
+	tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := plugin.Publish(tctx, "events", "two"); err != context.DeadlineExceeded {
+		fatalf("Publish to full subscriber: got %v, want context.DeadlineExceeded", err)
+	}
+
+	// Closing the plugin cancels its subscription.
+	if err := p.Close(); err != nil {
+		fatalf("Close: %v", err)
+	}
+	if v, ok := next(); !ok || v != "one" {
+		fatalf("plugin received %v, %v after Close, want buffered message", v, ok)
+	}
+	if _, ok := next(); ok {
+		fatalf("subscription not cancelled by Close")
+	}
+	if err := plugin.Publish(ctx, "events", "three"); err != nil {
+		fatalf("Publish with no subscribers: %v", err)
+	}
+
+	ch, cancelSub := plugin.Subscribe("host", 0)
+	go plugin.Publish(ctx, "host", 42)
+	if v := <-ch; v != 42 {
+		fatalf("host received %v, want 42", v)
+	}
+	cancelSub()
+	if _, ok := <-ch; ok {
+		fatalf("cancelled subscription still open")
+	}
+
+	// Cancelling a subscription releases a publisher blocked on it.
+	_, cancelSub = plugin.Subscribe("blocked", 0)
+	errc := make(chan error)
+	go func() { errc <- plugin.Publish(ctx, "blocked", 1) }()
+	time.Sleep(10 * time.Millisecond)
+	cancelSub()
+	if err := <-errc; err != nil {
+		fatalf("Publish to cancelled subscriber: %v", err)
+	}
+
+	// A subscriber can publish while it handles a message, even when
+	// the publisher that sent the message is still blocked and
+	// another goroutine is subscribing.
+	in1, _ := plugin.Subscribe("in", 0)
+	in2, _ := plugin.Subscribe("in", 0)
+	out, _ := plugin.Subscribe("out", 1)
+	go plugin.Publish(ctx, "in", "ping")
+	v := <-in1
+	go plugin.Subscribe("late", 0)
+	time.Sleep(10 * time.Millisecond)
+	go func() { errc <- plugin.Publish(ctx, "out", v) }()
+	select {
+	case err := <-errc:
+		if err != nil {
+			fatalf("Publish from subscriber: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		fatalf("Publish from subscriber blocked")
+	}
+	if v := <-in2; v != "ping" {
+		fatalf("second subscriber received %v, want ping", v)
+	}
+	if v := <-out; v != "ping" {
+		fatalf("out received %v, want ping", v)
+	}
+}
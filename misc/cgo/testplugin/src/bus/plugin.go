@@ -0,0 +1,20 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "plugin"
+
+var events <-chan interface{}
+
+func init() {
+	events, _ = plugin.Subscribe("events", 1)
+}
+
+func Next() (interface{}, bool) {
+	v, ok := <-events
+	return v, ok
+}
+
+func main() {}
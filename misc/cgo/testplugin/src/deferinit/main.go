@@ -0,0 +1,46 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"plugin"
+	"sync"
+)
+
+func main() {
+	p, err := plugin.OpenWithOptions("plugin.so", plugin.OpenOptions{DeferInit: true})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "deferinit: %v\n", err)
+		os.Exit(2)
+	}
+	if _, err := p.SymbolType("Inits"); err != nil {
+		fmt.Fprintf(os.Stderr, "deferinit: %v\n", err)
+		os.Exit(2)
+	}
+
+	var wg sync.WaitGroup
+	results := make([]int, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sym, err := p.Lookup("Inits")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "deferinit: %v\n", err)
+				os.Exit(2)
+			}
+			results[i] = sym.(func() int)()
+		}(i)
+	}
+	wg.Wait()
+	for _, n := range results {
+		if n != 1 {
+			fmt.Fprintf(os.Stderr, "deferinit: lookups saw init run %v times, want 1\n", results)
+			os.Exit(2)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "time"
+
+var inits int
+
+func init() {
+	time.Sleep(10 * time.Millisecond) // let concurrent lookups pile up
+	inits++
+}
+
+func Inits() int { return inits }
+
+func main() {}
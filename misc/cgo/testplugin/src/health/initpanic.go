@@ -0,0 +1,15 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "context"
+
+func init() {
+	panic("health: boom")
+}
+
+func HealthCheck(ctx context.Context) error { return nil }
+
+func main() {}
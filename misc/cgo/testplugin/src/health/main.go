@@ -0,0 +1,69 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"plugin"
+	"strings"
+	"time"
+)
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "health: "+format+"\n", args...)
+	os.Exit(2)
+}
+
+func main() {
+	p, err := plugin.Open("plugin.so")
+	if err != nil {
+		fatalf("%v", err)
+	}
+	sym, err := p.Lookup("Mode")
+	if err != nil {
+		fatalf("%v", err)
+	}
+	mode := sym.(*string)
+	ctx := context.Background()
+
+	if err := p.Health(ctx); err != nil {
+		fatalf("Health of healthy plugin: %v", err)
+	}
+	*mode = "fail"
+	if err := p.Health(ctx); err == nil || err.Error() != "unhealthy" {
+		fatalf("Health of failing plugin: %v", err)
+	}
+	*mode = "panic"
+	if err, ok := p.Health(ctx).(*plugin.PanicError); !ok || err.Value != "health: broken" {
+		fatalf("Health of panicking plugin: %v", err)
+	}
+	*mode = "hang"
+	tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := p.Health(tctx); err != context.DeadlineExceeded {
+		fatalf("Health of hanging plugin: %v", err)
+	}
+
+	q, err := plugin.Open("unnamed1.so")
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if err := q.Health(ctx); err != plugin.ErrNoHealthCheck {
+		fatalf("Health of plugin without HealthCheck: %v", err)
+	}
+
+	// A plugin whose deferred init fails reports that failure, not a
+	// missing HealthCheck. This must come last: after an init panic no
+	// more plugins can be initialized.
+	r, err := plugin.OpenWithOptions("health-initpanic.so", plugin.OpenOptions{DeferInit: true})
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if err := r.Health(ctx); err == nil || err == plugin.ErrNoHealthCheck || !strings.Contains(err.Error(), "health: boom") {
+		fatalf("Health of plugin whose deferred init panics: %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+var Mode string
+
+func HealthCheck(ctx context.Context) error {
+	switch Mode {
+	case "fail":
+		return errors.New("unhealthy")
+	case "panic":
+		panic("health: broken")
+	case "hang":
+		select {} // never returns, even when ctx is done
+	}
+	return nil
+}
+
+func main() {}
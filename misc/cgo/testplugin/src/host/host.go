@@ -5,10 +5,15 @@
 package main
 
 import (
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
 	"path/filepath"
 	"plugin"
+	"reflect"
 	"strings"
 
 	"common"
@@ -38,6 +43,9 @@ func testUnnamed() {
 	if err != nil {
 		log.Fatalf(`plugin.Open("unnamed2.so"): %v`, err)
 	}
+	if t, err := p.SymbolType("FuncInt"); err != nil || t != reflect.TypeOf(func() int { return 0 }) {
+		log.Fatalf(`unnamed2.so: SymbolType("FuncInt") = %v, %v, want func() int`, t, err)
+	}
 	fn, err = p.Lookup("FuncInt")
 	if err != nil {
 		log.Fatalf(`unnamed2.so: Lookup("FuncInt") failed: %v`, err)
@@ -47,6 +55,299 @@ func testUnnamed() {
 	}
 }
 
+// testLazy tests that the symbols of a plugin opened with OpenLazy
+// are resolved by Lookup and Symbols.
+func testLazy() {
+	p, err := plugin.OpenLazy("lazy.so")
+	if err != nil {
+		log.Fatalf(`plugin.OpenLazy("lazy.so"): %v`, err)
+	}
+	fn, err := p.Lookup("FuncInt")
+	if err != nil {
+		log.Fatalf(`lazy.so: Lookup("FuncInt") failed: %v`, err)
+	}
+	if got, want := fn.(func() int)(), 3; got != want {
+		log.Fatalf("lazy.so: FuncInt()=%d, want %d", got, want)
+	}
+	if _, err := p.Lookup("NoSuchSymbol"); err == nil {
+		log.Fatal(`lazy.so: Lookup("NoSuchSymbol") should have failed`)
+	}
+	syms := p.Symbols()
+	if fn, ok := syms["FuncInt"].(func() int); !ok || fn() != 3 {
+		log.Fatal(`lazy.so: Symbols()["FuncInt"] is not the function FuncInt`)
+	}
+	if v, ok := syms["Answer"].(*int); !ok || *v != 42 {
+		log.Fatalf(`lazy.so: Symbols()["Answer"] is %v, want *int pointing to 42`, syms["Answer"])
+	}
+}
+
+// testClose tests that a plugin stays usable until every Open has been
+// matched by a Close, and that a closed plugin can be opened again.
+// It must run after testUnnamed, which holds one reference to unnamed1.so.
+func testClose() {
+	p, err := plugin.Open("unnamed1.so")
+	if err != nil {
+		log.Fatalf(`plugin.Open("unnamed1.so"): %v`, err)
+	}
+	if err := p.Close(); err != nil {
+		log.Fatalf("unnamed1.so: first Close failed: %v", err)
+	}
+	if _, err := p.Lookup("FuncInt"); err != nil {
+		log.Fatalf(`unnamed1.so: Lookup("FuncInt") after first Close failed: %v`, err)
+	}
+	if err := p.Close(); err != nil {
+		log.Fatalf("unnamed1.so: second Close failed: %v", err)
+	}
+	if _, err := p.Lookup("FuncInt"); err == nil || !strings.Contains(err.Error(), "closed") {
+		log.Fatalf(`unnamed1.so: Lookup("FuncInt") after last Close: got %v, want error mentioning "closed"`, err)
+	}
+	if err := p.Close(); err == nil {
+		log.Fatal("unnamed1.so: Close of a closed plugin should have failed")
+	}
+
+	p, err = plugin.OpenWithOptions("unnamed1.so", plugin.OpenOptions{Lazy: true})
+	if err != nil {
+		log.Fatalf(`plugin.OpenWithOptions("unnamed1.so") after Close: %v`, err)
+	}
+	fn, err := p.Lookup("FuncInt")
+	if err != nil {
+		log.Fatalf(`unnamed1.so: Lookup("FuncInt") after reopen failed: %v`, err)
+	}
+	if got, want := fn.(func() int)(), 1; got != want {
+		log.Fatalf("unnamed1.so: FuncInt()=%d after reopen, want %d", got, want)
+	}
+}
+
+// testReplace tests that a plugin file replaced after the plugin
+// was closed is not loaded, since the old module is still in use.
+func testReplace() {
+	p, err := plugin.Open("replace.so")
+	if err != nil {
+		log.Fatalf(`plugin.Open("replace.so"): %v`, err)
+	}
+	if err := p.Close(); err != nil {
+		log.Fatalf("replace.so: Close failed: %v", err)
+	}
+	if err := os.Rename("replace-v2.so", "replace.so"); err != nil {
+		log.Fatal(err)
+	}
+	_, err = plugin.Open("replace.so")
+	if oerr, ok := err.(*plugin.OpenError); !ok || oerr.Stage != "moduleinit" || !strings.Contains(err.Error(), "replaced") {
+		log.Fatalf(`plugin.Open("replace.so") after replacing the closed plugin's file: got %v, want *plugin.OpenError mentioning "replaced"`, err)
+	}
+}
+
+// testRetry tests that a plugin that failed to load can be loaded
+// once the file is replaced. retry.so starts out as a copy of
+// plugin-mismatch.so, and is replaced by retry-good.so.
+func testRetry() {
+	if _, err := plugin.Open("retry.so"); err == nil {
+		log.Fatal(`plugin.Open("retry.so"): should have failed`)
+	}
+	_, err := plugin.Open("retry.so")
+	if err == nil || !strings.Contains(err.Error(), "previous failure") {
+		log.Fatalf(`plugin.Open("retry.so"): got %v, want error mentioning "previous failure"`, err)
+	}
+	if err := os.Rename("retry-good.so", "retry.so"); err != nil {
+		log.Fatal(err)
+	}
+	p, err := plugin.Open("retry.so")
+	if err != nil {
+		log.Fatalf(`plugin.Open("retry.so") after replacing the file: %v`, err)
+	}
+	f, err := p.Lookup("Retried")
+	if err != nil {
+		log.Fatalf(`retry.so: Lookup("Retried") failed: %v`, err)
+	}
+	if got, want := f.(func() string)(), "retried"; got != want {
+		log.Fatalf("retry.so: Retried()=%q, want %q", got, want)
+	}
+}
+
+// testBind tests that Bind fills in functions and variables
+// and reports every symbol it cannot bind.
+func testBind(p *plugin.Plugin) {
+	var api struct {
+		F     func() int
+		Seven *int `plugin:"Seven"`
+		V     *int `plugin:"-"`
+	}
+	if err := p.Bind(&api); err != nil {
+		log.Fatalf("Bind: %v", err)
+	}
+	if got := api.F(); got != 3 {
+		log.Fatalf("Bind: F() = %d, want 3", got)
+	}
+	if api.Seven == nil || *api.Seven != 7 || api.V != nil {
+		log.Fatalf("Bind: Seven = %v, V = %v", api.Seven, api.V)
+	}
+
+	if t, err := p.SymbolType("Seven"); err != nil || t != reflect.TypeOf(new(int)) {
+		log.Fatalf(`SymbolType("Seven") = %v, %v, want *int`, t, err)
+	}
+	if _, err := p.SymbolType("Missing"); err == nil {
+		log.Fatal(`SymbolType("Missing") should have failed`)
+	}
+
+	var bad struct {
+		Missing func()
+		F       func() string
+	}
+	err := p.Bind(&bad)
+	if err == nil || !strings.Contains(err.Error(), "Missing not found") || !strings.Contains(err.Error(), "F has type func() int") {
+		log.Fatalf("Bind with bad fields: got %v", err)
+	}
+
+	if err := p.Bind(nil); err == nil {
+		log.Fatal("Bind(nil) should have failed")
+	}
+}
+
+// testVersions tests LookupVersion and NegotiateVersion.
+func testVersions(p *plugin.Plugin) {
+	f, err := p.LookupVersion("Version", "v1")
+	if err != nil {
+		log.Fatalf(`LookupVersion("Version", "v1"): %v`, err)
+	}
+	if got := f.(func() string)(); got != "v1" {
+		log.Fatalf(`LookupVersion("Version", "v1") returned version %q`, got)
+	}
+	f, v, err := p.NegotiateVersion("Version", "v3", "v2", "v1")
+	if err != nil {
+		log.Fatalf("NegotiateVersion: %v", err)
+	}
+	if got := f.(func() string)(); v != "v2" || got != "v2" {
+		log.Fatalf("NegotiateVersion chose %s, returning %s, want v2", v, got)
+	}
+	if _, _, err := p.NegotiateVersion("Version", "v3"); err == nil {
+		log.Fatal(`NegotiateVersion("Version", "v3") should have failed`)
+	}
+}
+
+// testPlugins tests that Plugins reports loaded and failed plugins.
+func testPlugins(p *plugin.Plugin) {
+	dir, err := filepath.EvalSymlinks(".")
+	if err == nil {
+		dir, err = filepath.Abs(dir)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	var found, foundFailed bool
+	for _, info := range plugin.Plugins() {
+		switch info.Path {
+		case filepath.Join(dir, "plugin1.so"):
+			if info.PluginPath != "plugin1" || info.Plugin != p || info.Err != nil || info.Loaded.IsZero() {
+				log.Fatalf("plugin.Plugins(): bad entry for plugin1.so: %+v", info)
+			}
+			found = true
+		case filepath.Join(dir, "plugin-mismatch.so"):
+			if info.Plugin != nil || info.Err == nil {
+				log.Fatalf("plugin.Plugins(): bad entry for plugin-mismatch.so: %+v", info)
+			}
+			foundFailed = true
+		}
+	}
+	if got, want := p.Path(), filepath.Join(dir, "plugin1.so"); got != want {
+		log.Fatalf("plugin1.so: Path() = %q, want %q", got, want)
+	}
+	if !found || !foundFailed {
+		log.Fatal("plugin.Plugins(): missing plugin1.so or plugin-mismatch.so")
+	}
+}
+
+// testHooks tests that registered hooks see loads, lookups and
+// unloads, and that an OnLoad hook can reject a plugin.
+func testHooks() {
+	var lookups, unloads int
+	errVeto := errors.New("vetoed")
+	plugin.RegisterHooks(plugin.Hooks{
+		OnLoad: func(path string) error {
+			if filepath.Base(path) == "plugin-veto.so" {
+				return errVeto
+			}
+			return nil
+		},
+		OnUnload: func(*plugin.Plugin) { unloads++ },
+		OnLookup: func(p *plugin.Plugin, symName string, err error) {
+			if symName == "FuncInt" {
+				lookups++
+			}
+		},
+	})
+
+	_, err := plugin.Open("plugin-veto.so")
+	if oerr, ok := err.(*plugin.OpenError); !ok || oerr.Stage != "hook" || oerr.Err != errVeto {
+		log.Fatalf(`plugin.Open("plugin-veto.so"): got %v, want *plugin.OpenError from OnLoad hook`, err)
+	}
+
+	p, err := plugin.Open("unnamed2.so")
+	if err != nil {
+		log.Fatalf(`plugin.Open("unnamed2.so"): %v`, err)
+	}
+	p.Lookup("FuncInt")
+	p.Close()
+	p.Close()
+	if lookups != 1 || unloads != 1 {
+		log.Fatalf("hooks saw %d lookups and %d unloads, want 1 and 1", lookups, unloads)
+	}
+}
+
+// testPolicy tests that a policy set by SetPolicy can veto opens,
+// including opens of a plugin that is already loaded.
+func testPolicy() {
+	errDenied := errors.New("denied")
+	var seen []string
+	plugin.SetPolicy(func(info plugin.PluginInfo) error {
+		seen = append(seen, filepath.Base(info.Path))
+		if filepath.Base(info.Path) == "plugin2-link.so" {
+			return errDenied
+		}
+		return nil
+	})
+	defer plugin.SetPolicy(nil)
+
+	_, err := plugin.Open("plugin2-link.so")
+	if oerr, ok := err.(*plugin.OpenError); !ok || oerr.Stage != "policy" || oerr.Err != errDenied {
+		log.Fatalf(`plugin.Open("plugin2-link.so"): got %v, want *plugin.OpenError from policy`, err)
+	}
+	if _, err := plugin.Open("plugin1.so"); err != nil {
+		log.Fatalf(`plugin.Open("plugin1.so") with policy: %v`, err)
+	}
+	if len(seen) != 2 || seen[0] != "plugin2-link.so" || seen[1] != "plugin1.so" {
+		log.Fatalf("policy saw %v, want [plugin2-link.so plugin1.so]", seen)
+	}
+}
+
+// fileSHA256 returns the SHA-256 digest of the named file.
+func fileSHA256(name string) []byte {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// testSHA256 tests that OpenOptions.SHA256 is checked when opening
+// plugin2.so, which p2 has already loaded.
+func testSHA256(p2 *plugin.Plugin) {
+	sum := fileSHA256("plugin2.so")
+	p, err := plugin.OpenWithOptions("plugin2.so", plugin.OpenOptions{SHA256: sum})
+	if err != nil {
+		log.Fatalf(`plugin.OpenWithOptions("plugin2.so") with SHA256: %v`, err)
+	}
+	if p != p2 {
+		log.Fatal(`plugin.OpenWithOptions("plugin2.so") with SHA256 did not return the already opened plugin`)
+	}
+
+	sum[0]++
+	_, err = plugin.OpenWithOptions("plugin2.so", plugin.OpenOptions{SHA256: sum})
+	if oerr, ok := err.(*plugin.OpenError); !ok || oerr.Stage != "verify" {
+		log.Fatalf(`plugin.OpenWithOptions("plugin2.so") with wrong SHA256: got %v, want *plugin.OpenError with Stage "verify"`, err)
+	}
+}
+
 func main() {
 	if got, want := common.X, 3*5; got != want {
 		log.Fatalf("before plugin load common.X=%d, want %d", got, want)
@@ -70,6 +371,14 @@ func main() {
 		log.Fatalf("plugin1.Seven=%d, want %d", got, want)
 	}
 
+	syms := p.Symbols()
+	if _, ok := syms["Seven"].(*int); !ok {
+		log.Fatalf(`plugin1.Symbols()["Seven"] is %T, want *int`, syms["Seven"])
+	}
+	if _, ok := syms["ReadCommonX"].(func() int); !ok {
+		log.Fatalf(`plugin1.Symbols()["ReadCommonX"] is %T, want func() int`, syms["ReadCommonX"])
+	}
+
 	readFunc, err := p.Lookup("ReadCommonX")
 	if err != nil {
 		log.Fatalf(`plugin1.Lookup("ReadCommonX") failed: %v`, err)
@@ -143,6 +452,9 @@ func main() {
 	if s := err.Error(); !strings.Contains(s, "already loaded") {
 		log.Fatal(`plugin.Open("plugin2.so"): error does not mention "already loaded"`)
 	}
+	if s := err.Error(); !strings.Contains(s, "plugin path plugin2 is in use by ") || !strings.HasSuffix(s, "/plugin2.so") {
+		log.Fatalf(`plugin.Open("plugin2-dup.so"): error does not name plugin2.so: %v`, s)
+	}
 
 	_, err = plugin.Open("plugin-mismatch.so")
 	if err == nil {
@@ -151,6 +463,23 @@ func main() {
 	if s := err.Error(); !strings.Contains(s, "different version") {
 		log.Fatalf(`plugin.Open("plugin-mismatch.so"): error does not mention "different version": %v`, s)
 	}
+	if s := err.Error(); !strings.HasSuffix(s, "version of package common than the program") {
+		log.Fatalf(`plugin.Open("plugin-mismatch.so"): error does not say which copy of common is in use: %v`, s)
+	}
+	if oerr, ok := err.(*plugin.OpenError); !ok || oerr.Stage != "version" || oerr.Path != "plugin-mismatch.so" {
+		log.Fatalf(`plugin.Open("plugin-mismatch.so"): got %#v, want *plugin.OpenError with Stage "version"`, err)
+	}
+
+	_, err = plugin.Open("nonexistent.so")
+	if oerr, ok := err.(*plugin.OpenError); !ok || oerr.Stage != "realpath" {
+		log.Fatalf(`plugin.Open("nonexistent.so"): got %#v, want *plugin.OpenError with Stage "realpath"`, err)
+	}
+	for _, name := range []string{"nonexistent.SO", "nonexistent.dll", "nonexistent.Dll"} {
+		_, err = plugin.Open(name)
+		if err == nil || !strings.HasPrefix(err.Error(), `plugin.Open("nonexistent"): `) {
+			log.Fatalf(`plugin.Open(%q): got %v, want error naming plugin "nonexistent"`, name, err)
+		}
+	}
 
 	_, err = plugin.Open("plugin2-dup.so")
 	if err == nil {
@@ -161,6 +490,15 @@ func main() {
 		log.Fatalf(`plugin.Open("plugin2.so"): second open with same name failed: %v`, err)
 	}
 
+	// plugin2-link.so is a hard link to plugin2.so.
+	p2link, err := plugin.Open("plugin2-link.so")
+	if err != nil {
+		log.Fatalf(`plugin.Open("plugin2-link.so"): open of hard link failed: %v`, err)
+	}
+	if p2link != p2 {
+		log.Fatal(`plugin.Open("plugin2-link.so"): hard link did not return the already opened plugin`)
+	}
+
 	// Test that unexported types with the same names in
 	// different plugins do not interfere with each other.
 	//
@@ -170,7 +508,17 @@ func main() {
 	UnexportedNameReuse, _ = p2.Lookup("UnexportedNameReuse")
 	UnexportedNameReuse.(func())()
 
+	testSHA256(p2)
+	testBind(p)
+	testVersions(p)
+	testPlugins(p)
+	testRetry()
 	testUnnamed()
+	testLazy()
+	testClose()
+	testReplace()
+	testHooks()
+	testPolicy()
 
 	fmt.Println("PASS")
 }
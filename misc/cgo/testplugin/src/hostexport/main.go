@@ -0,0 +1,54 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"plugin"
+)
+
+var count int
+
+type counter struct{}
+
+func (counter) Add(n int) int { return n + 1 }
+
+func main() {
+	plugin.Export("Greet", func(name string) string { return "hello, " + name })
+	plugin.Export("Count", &count)
+	plugin.ProvideService("counter", counter{})
+
+	p, err := plugin.Open("plugin.so")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hostexport: %v\n", err)
+		os.Exit(2)
+	}
+	sym, err := p.Lookup("InitErr")
+	if err == nil && *sym.(*error) != nil {
+		err = *sym.(*error)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hostexport: plugin init: %v\n", err)
+		os.Exit(2)
+	}
+	sym, err = p.Lookup("Hello")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hostexport: %v\n", err)
+		os.Exit(2)
+	}
+	if got, want := sym.(func() string)(), "hello, plugin"; got != want {
+		fmt.Fprintf(os.Stderr, "hostexport: Hello() = %q, want %q\n", got, want)
+		os.Exit(2)
+	}
+	if count != 1 {
+		fmt.Fprintf(os.Stderr, "hostexport: count = %d after Hello, want 1\n", count)
+		os.Exit(2)
+	}
+	if _, err := plugin.LookupHost("Missing"); err == nil {
+		fmt.Fprintln(os.Stderr, "hostexport: LookupHost of unexported name succeeded")
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"plugin"
+)
+
+var greet func(string) string
+
+var counter interface {
+	Add(int) int
+}
+
+// InitErr reports to the host what went wrong in init.
+var InitErr error
+
+func init() {
+	InitErr = initServices()
+}
+
+func initServices() error {
+	if err := plugin.RequireService("counter", &counter); err != nil {
+		return err
+	}
+	var wrong *int
+	if err := plugin.RequireService("counter", &wrong); err == nil {
+		return errors.New("RequireService into mistyped pointer succeeded")
+	}
+	if err := plugin.RequireService("missing", &wrong); err == nil {
+		return errors.New("RequireService of missing service succeeded")
+	}
+
+	sym, err := plugin.LookupHost("Greet")
+	if err != nil {
+		return err
+	}
+	greet = sym.(func(string) string)
+	return nil
+}
+
+func Hello() string {
+	sym, err := plugin.LookupHost("Count")
+	if err != nil {
+		panic(err)
+	}
+	*sym.(*int) = counter.Add(*sym.(*int))
+	return greet("plugin")
+}
+
+func main() {}
@@ -0,0 +1,53 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"plugin"
+)
+
+// main opens plugin.so with InitOutput. With argument "defer" it
+// opens it with DeferInit, so the first Lookup runs its init; with
+// "skip" it opens it with SkipInit first.
+func main() {
+	var buf bytes.Buffer
+	opts := plugin.OpenOptions{InitOutput: &buf}
+	mode := ""
+	if len(os.Args) > 1 {
+		mode = os.Args[1]
+	}
+	switch mode {
+	case "defer":
+		opts.DeferInit = true
+	case "skip":
+		if _, err := plugin.OpenWithOptions("plugin.so", plugin.OpenOptions{SkipInit: true}); err != nil {
+			fmt.Fprintf(os.Stderr, "initoutput: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	p, err := plugin.OpenWithOptions("plugin.so", opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "initoutput: %v\n", err)
+		os.Exit(2)
+	}
+	if mode == "defer" {
+		if buf.Len() != 0 {
+			fmt.Fprintf(os.Stderr, "initoutput: captured %q before Lookup\n", buf.String())
+			os.Exit(2)
+		}
+		if _, err := p.Lookup("Inited"); err != nil {
+			fmt.Fprintf(os.Stderr, "initoutput: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if got, want := buf.String(), "initoutput: to stdout\ninitoutput: to stderr\n"; got != want {
+		fmt.Fprintf(os.Stderr, "initoutput: captured %q, want %q\n", got, want)
+		os.Exit(2)
+	}
+	fmt.Println("PASS")
+}
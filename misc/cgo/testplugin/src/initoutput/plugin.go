@@ -0,0 +1,19 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func init() {
+	fmt.Println("initoutput: to stdout")
+	fmt.Fprintln(os.Stderr, "initoutput: to stderr")
+}
+
+var Inited = true
+
+func main() {}
@@ -0,0 +1,45 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"plugin"
+	"strings"
+)
+
+func main() {
+	_, err := plugin.Open("plugin.so")
+	oerr, ok := err.(*plugin.OpenError)
+	if !ok || oerr.Stage != "init" {
+		fmt.Fprintf(os.Stderr, "initpanic: Open returned %v, want *plugin.OpenError at stage init\n", err)
+		os.Exit(2)
+	}
+	perr, ok := oerr.Err.(*plugin.PanicError)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "initpanic: Open returned %v, want *plugin.PanicError\n", oerr.Err)
+		os.Exit(2)
+	}
+	if perr.Value != "initpanic: bad configuration" {
+		fmt.Fprintf(os.Stderr, "initpanic: panic value %v\n", perr.Value)
+		os.Exit(2)
+	}
+	if !strings.Contains(string(perr.Stack), "initpanic") {
+		fmt.Fprintf(os.Stderr, "initpanic: stack does not mention the plugin:\n%s\n", perr.Stack)
+		os.Exit(2)
+	}
+	if _, err := plugin.Open("plugin.so"); err == nil {
+		fmt.Fprintln(os.Stderr, "initpanic: second Open should have failed")
+		os.Exit(2)
+	}
+
+	// No plugin can be loaded once an init function has panicked.
+	_, err = plugin.Open("initpanic-other.so")
+	if oerr, ok := err.(*plugin.OpenError); !ok || oerr.Stage != "moduleinit" || !strings.Contains(err.Error(), "panicked") {
+		fmt.Fprintf(os.Stderr, "initpanic: Open after init panic returned %v, want *plugin.OpenError mentioning the panic\n", err)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// #include <stdint.h>
+// static int call(uintptr_t fn) { return ((int (*)(void))fn)(); }
+import "C"
+
+import (
+	"fmt"
+	"os"
+	"plugin"
+	"strings"
+)
+
+func main() {
+	l, err := plugin.OpenC("./libnative.so")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "native: %v\n", err)
+		os.Exit(2)
+	}
+	fn, err := l.LookupProc("native_answer")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "native: %v\n", err)
+		os.Exit(2)
+	}
+	if got := C.call(C.uintptr_t(fn)); got != 42 {
+		fmt.Fprintf(os.Stderr, "native: native_answer() = %d, want 42\n", got)
+		os.Exit(2)
+	}
+	if _, err := l.LookupProc("no_such_function"); err == nil {
+		fmt.Fprintln(os.Stderr, "native: LookupProc of missing symbol succeeded")
+		os.Exit(2)
+	}
+	if err := l.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "native: Close: %v\n", err)
+		os.Exit(2)
+	}
+	if _, err := l.LookupProc("native_answer"); err == nil {
+		fmt.Fprintln(os.Stderr, "native: LookupProc after Close succeeded")
+		os.Exit(2)
+	}
+	_, err = plugin.OpenC("./libmissing.so")
+	if err == nil {
+		fmt.Fprintln(os.Stderr, "native: OpenC of missing library succeeded")
+		os.Exit(2)
+	}
+	if !strings.HasPrefix(err.Error(), `plugin.OpenC("./libmissing"): `) {
+		fmt.Fprintf(os.Stderr, "native: OpenC of missing library returned %q, want error from plugin.OpenC\n", err)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"plugin"
+	"strings"
+)
+
+func main() {
+	p, err := plugin.Open("plugin.so")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "onunload: %v\n", err)
+		os.Exit(2)
+	}
+	sym, err := p.Lookup("Order")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "onunload: %v\n", err)
+		os.Exit(2)
+	}
+	order := sym.(func() []string)
+	if _, err := plugin.Open("plugin.so"); err != nil {
+		fmt.Fprintf(os.Stderr, "onunload: second Open: %v\n", err)
+		os.Exit(2)
+	}
+
+	p.Close()
+	if got := order(); len(got) != 0 {
+		fmt.Fprintf(os.Stderr, "onunload: functions ran before the last Close: %v\n", got)
+		os.Exit(2)
+	}
+	p.Close()
+	if got := strings.Join(order(), ","); got != "second,first" {
+		fmt.Fprintf(os.Stderr, "onunload: functions ran in order %q, want \"second,first\"\n", got)
+		os.Exit(2)
+	}
+
+	defer func() {
+		if recover() == nil {
+			fmt.Fprintln(os.Stderr, "onunload: OnUnload from the host did not panic")
+			os.Exit(2)
+		}
+	}()
+	plugin.OnUnload(func() {})
+}
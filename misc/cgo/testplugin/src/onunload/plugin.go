@@ -0,0 +1,18 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "plugin"
+
+var order []string
+
+func init() {
+	plugin.OnUnload(func() { order = append(order, "first") })
+	plugin.OnUnload(func() { order = append(order, "second") })
+}
+
+func Order() []string { return order }
+
+func main() {}
@@ -0,0 +1,49 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"plugin"
+)
+
+// Directory openall holds a.so and b.DLL, which are plugins,
+// c.so, which is not, and README, which is ignored.
+func main() {
+	ps, err := plugin.OpenAll("openall", plugin.OpenOptions{})
+	check("OpenAll", ps, err)
+
+	// The plugins are already open, so OpenAllParallel returns them
+	// again; a.so and b.DLL share no package outside the program.
+	pps, err := plugin.OpenAllParallel("openall", plugin.OpenOptions{})
+	check("OpenAllParallel", pps, err)
+	for i := range ps {
+		if pps[i] != ps[i] {
+			fmt.Fprintf(os.Stderr, "openall: OpenAllParallel returned a different plugin %d\n", i)
+			os.Exit(2)
+		}
+	}
+}
+
+func check(fn string, ps []*plugin.Plugin, err error) {
+	if len(ps) != 2 {
+		fmt.Fprintf(os.Stderr, "openall: %s opened %d plugins, want 2\n", fn, len(ps))
+		os.Exit(2)
+	}
+	if f, _ := ps[0].Lookup("FuncInt"); f == nil || f.(func() int)() != 1 {
+		fmt.Fprintf(os.Stderr, "openall: %s: first plugin is not a.so\n", fn)
+		os.Exit(2)
+	}
+	list, ok := err.(plugin.ErrorList)
+	if !ok || len(list) != 1 {
+		fmt.Fprintf(os.Stderr, "openall: %s: got error %v, want ErrorList of 1 error\n", fn, err)
+		os.Exit(2)
+	}
+	if oerr, ok := list[0].(*plugin.OpenError); !ok || oerr.Path != "openall/c.so" {
+		fmt.Fprintf(os.Stderr, "openall: %s: got error %v, want error for openall/c.so\n", fn, list[0])
+		os.Exit(2)
+	}
+}
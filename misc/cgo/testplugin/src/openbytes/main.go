@@ -0,0 +1,78 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"plugin"
+	"strings"
+)
+
+func main() {
+	data, err := ioutil.ReadFile("plugin.so")
+	if err != nil {
+		panic(err)
+	}
+	// Remove the file, so the plugin can only come from data.
+	if err := os.Remove("plugin.so"); err != nil {
+		panic(err)
+	}
+
+	p, err := plugin.OpenBytes("bytes.so", data)
+	if err != nil {
+		panic(err)
+	}
+	f, err := p.Lookup("F")
+	if err != nil {
+		panic(err)
+	}
+	if got, want := f.(func() int)(), 42; got != want {
+		fmt.Fprintf(os.Stderr, "openbytes: F()=%d, want %d\n", got, want)
+		os.Exit(2)
+	}
+
+	if got := p.Path(); got != "bytes.so" {
+		fmt.Fprintf(os.Stderr, "openbytes: Path()=%q, want %q\n", got, "bytes.so")
+		os.Exit(2)
+	}
+
+	// Opening the same contents again returns the same plugin.
+	p2, err := plugin.OpenBytes("again.so", data)
+	if err != nil {
+		panic(err)
+	}
+	if p2 != p {
+		fmt.Fprintln(os.Stderr, "openbytes: second OpenBytes of the same data returned a different plugin")
+		os.Exit(2)
+	}
+
+	_, err = plugin.OpenBytes("garbage.so", []byte("not a plugin"))
+	if oerr, ok := err.(*plugin.OpenError); !ok || oerr.Path != "garbage.so" || oerr.Stage != "load" {
+		fmt.Fprintf(os.Stderr, "openbytes: OpenBytes of garbage returned %v, want *plugin.OpenError for garbage.so\n", err)
+		os.Exit(2)
+	}
+	if !strings.HasPrefix(err.Error(), `plugin.OpenBytes("garbage"): `) {
+		fmt.Fprintf(os.Stderr, "openbytes: OpenBytes of garbage returned %q, want error from plugin.OpenBytes\n", err)
+		os.Exit(2)
+	}
+
+	// Only the plugin that loaded is listed, under its name.
+	infos := plugin.Plugins()
+	if len(infos) != 1 || infos[0].Path != "bytes.so" || infos[0].Plugin != p {
+		fmt.Fprintf(os.Stderr, "openbytes: Plugins()=%+v, want only bytes.so\n", infos)
+		os.Exit(2)
+	}
+
+	// Once the plugin is closed, its contents cannot be opened again.
+	p.Close()
+	p.Close()
+	_, err = plugin.OpenBytes("bytes.so", data)
+	if err == nil || !strings.Contains(err.Error(), "closed") {
+		fmt.Fprintf(os.Stderr, "openbytes: OpenBytes after Close returned %v, want error mentioning \"closed\"\n", err)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"plugin"
+	"time"
+)
+
+func main() {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := plugin.OpenContext(ctx, "plugin.so")
+	if oerr, ok := err.(*plugin.OpenError); !ok || oerr.Stage != "init" || oerr.Err != context.DeadlineExceeded {
+		fmt.Fprintf(os.Stderr, "openctx: OpenContext returned %v, want *plugin.OpenError with context.DeadlineExceeded\n", err)
+		os.Exit(2)
+	}
+	if _, err := plugin.Open("plugin.so"); err == nil {
+		fmt.Fprintln(os.Stderr, "openctx: Open after timed out init should have failed")
+		os.Exit(2)
+	}
+}
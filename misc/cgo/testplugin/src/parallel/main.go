@@ -0,0 +1,66 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"plugin"
+	"sync"
+)
+
+// Open two plugins from several goroutines at once. Opens of the same
+// file must return the same *Plugin, whatever name they use.
+func main() {
+	names := []string{"unnamed1.so", "unnamed2.so", "./unnamed1.so", "./unnamed2.so"}
+	const n = 8
+	var wg sync.WaitGroup
+	ps := make([]*plugin.Plugin, n*len(names))
+	errs := make([]error, n*len(names))
+	for i := range ps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ps[i], errs[i] = plugin.Open(names[i%len(names)])
+		}(i)
+	}
+	wg.Wait()
+	for i, p := range ps {
+		if errs[i] != nil {
+			fmt.Fprintf(os.Stderr, "parallel: %v\n", errs[i])
+			os.Exit(2)
+		}
+		if p != ps[i%2] {
+			fmt.Fprintf(os.Stderr, "parallel: Open(%q) returned a different *Plugin\n", names[i%len(names)])
+			os.Exit(2)
+		}
+	}
+	if ps[0] == ps[1] {
+		fmt.Fprintln(os.Stderr, "parallel: unnamed1.so and unnamed2.so opened as the same plugin")
+		os.Exit(2)
+	}
+
+	// Opens that wait for a failing load of the same file report
+	// the stage at which it failed.
+	if err := ioutil.WriteFile("parallel-bad.so", []byte("not a plugin"), 0666); err != nil {
+		panic(err)
+	}
+	defer os.Remove("parallel-bad.so")
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = plugin.Open("parallel-bad.so")
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if oerr, ok := err.(*plugin.OpenError); !ok || oerr.Stage != "load" {
+			fmt.Fprintf(os.Stderr, "parallel: Open of a bad file returned %v, want *plugin.OpenError with Stage \"load\"\n", err)
+			os.Exit(2)
+		}
+	}
+}
@@ -23,6 +23,9 @@ func ReadCommonX() int {
 
 var Seven int
 
+func Version_v1() string { return "v1" }
+func Version_v2() string { return "v2" }
+
 func call(fn func()) {
 	fn()
 }
@@ -0,0 +1,32 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"plugin"
+)
+
+// Run from a directory holding neither plugin, with GOPLUGINPATH
+// listing the directory of viaenv.so and viaexe.so in the plugins
+// subdirectory of the directory holding this program.
+func main() {
+	for _, name := range []string{"viaenv.so", "viaexe.so"} {
+		p, err := plugin.Open(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "pluginpath: %v\n", err)
+			os.Exit(2)
+		}
+		if _, err := p.Lookup("FuncInt"); err != nil {
+			fmt.Fprintf(os.Stderr, "pluginpath: %s: %v\n", name, err)
+			os.Exit(2)
+		}
+	}
+	if _, err := plugin.Open("missing.so"); err == nil {
+		fmt.Fprintln(os.Stderr, "pluginpath: Open of missing plugin succeeded")
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"plugin"
+)
+
+func main() {
+	p, err := plugin.OpenWithOptions("plugin.so", plugin.OpenOptions{SkipInit: true})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "skipinit: %v\n", err)
+		os.Exit(2)
+	}
+	sym, err := p.Lookup("Inits")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "skipinit: %v\n", err)
+		os.Exit(2)
+	}
+	inits := sym.(*int)
+	if *inits != 0 {
+		fmt.Fprintf(os.Stderr, "skipinit: init ran %d times with SkipInit\n", *inits)
+		os.Exit(2)
+	}
+	sym, err = p.Lookup("Static")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "skipinit: %v\n", err)
+		os.Exit(2)
+	}
+	if got := *sym.(*int); got != 7 {
+		fmt.Fprintf(os.Stderr, "skipinit: Static=%d with SkipInit, want 7\n", got)
+		os.Exit(2)
+	}
+
+	// Opening the file with DeferInit leaves init to the next Lookup.
+	if _, err := plugin.OpenWithOptions("plugin.so", plugin.OpenOptions{DeferInit: true}); err != nil {
+		fmt.Fprintf(os.Stderr, "skipinit: %v\n", err)
+		os.Exit(2)
+	}
+	if *inits != 0 {
+		fmt.Fprintf(os.Stderr, "skipinit: init ran %d times after open with DeferInit\n", *inits)
+		os.Exit(2)
+	}
+	if _, err := p.Lookup("Inits"); err != nil {
+		fmt.Fprintf(os.Stderr, "skipinit: %v\n", err)
+		os.Exit(2)
+	}
+	if *inits != 1 {
+		fmt.Fprintf(os.Stderr, "skipinit: init ran %d times after Lookup, want 1\n", *inits)
+		os.Exit(2)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := plugin.Open("plugin.so"); err != nil {
+			fmt.Fprintf(os.Stderr, "skipinit: %v\n", err)
+			os.Exit(2)
+		}
+		if *inits != 1 {
+			fmt.Fprintf(os.Stderr, "skipinit: init ran %d times after Open, want 1\n", *inits)
+			os.Exit(2)
+		}
+	}
+}
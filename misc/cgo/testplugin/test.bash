@@ -15,8 +15,8 @@ goos=$(go env GOOS)
 goarch=$(go env GOARCH)
 
 function cleanup() {
-	rm -f plugin*.so unnamed*.so iface*.so issue*
-	rm -rf host pkg sub iface
+	rm -f plugin*.so unnamed*.so lazy.so replace*.so iface*.so retry*.so issue* openbytes openctx initpanic initpanic-other.so onunload parallel native libnative.so hostexport health health-initpanic.so initoutput skipinit deferinit bus
+	rm -rf host pkg sub iface pluginpath openall
 }
 trap cleanup EXIT
 
@@ -26,10 +26,17 @@ mkdir sub
 GOPATH=$(pwd) go build -i -gcflags "$GO_GCFLAGS" -buildmode=plugin plugin1
 GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin plugin2
 cp plugin2.so plugin2-dup.so
+ln plugin2.so plugin2-link.so
+cp plugin2.so plugin-veto.so
 GOPATH=$(pwd)/altpath go build -gcflags "$GO_GCFLAGS" -buildmode=plugin plugin-mismatch
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o=retry-good.so src/retry/plugin.go
+cp plugin-mismatch.so retry.so
 GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o=sub/plugin1.so sub/plugin1
 GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o=unnamed1.so unnamed1/main.go
 GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o=unnamed2.so unnamed2/main.go
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o=lazy.so src/lazy/plugin.go
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o=replace.so src/replace/plugin.go
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin "-ldflags=-X main.Version=v2" -o=replace-v2.so src/replace/plugin.go
 GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" host
 
 LD_LIBRARY_PATH=$(pwd) ./host
@@ -81,6 +88,92 @@ GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o issue22175_pl
 GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -o issue22175 src/issue22175/main.go
 ./issue22175
 
+# Test that GODEBUG=pluginload=1 traces the stages of a load.
+GODEBUG=pluginload=1 ./issue22175 2>&1 | grep -q 'pluginload: issue22175_plugin1.so: done .*: ok'
+
+# Test loading a plugin from memory
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o plugin.so src/openbytes/plugin.go
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -o openbytes src/openbytes/main.go
+./openbytes
+
+# Test that OpenContext gives up on an init function that blocks
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o plugin.so src/openctx/plugin.go
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -o openctx src/openctx/main.go
+./openctx
+
+# Test that a panic during plugin initialization is reported by Open
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o plugin.so src/initpanic/plugin.go
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o initpanic-other.so src/initpanic/other.go
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -o initpanic src/initpanic/main.go
+./initpanic
+
+# Test that Open searches GOPLUGINPATH and the executable's plugins directory
+mkdir -p pluginpath/env pluginpath/bin/plugins
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o=pluginpath/env/viaenv.so unnamed1/main.go
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o=pluginpath/bin/plugins/viaexe.so unnamed2/main.go
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -o pluginpath/bin/pluginpath src/pluginpath/main.go
+(cd pluginpath && GOPLUGINPATH=$(pwd)/none:$(pwd)/env ./bin/pluginpath)
+
+# Test that Close runs the functions a plugin registers with OnUnload
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o plugin.so src/onunload/plugin.go
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -o onunload src/onunload/main.go
+./onunload
+
+# Test opening plugins from several goroutines at once
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -o parallel src/parallel/main.go
+./parallel
+
+# Test loading a native shared library with OpenC
+$(go env CC) $(go env GOGCCFLAGS) -shared -o libnative.so src/native/native.c
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -o native src/native/main.go
+./native
+
+# Test that plugins can look up values exported by the host
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o plugin.so src/hostexport/plugin.go
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -o hostexport src/hostexport/main.go
+./hostexport
+
+# Test Health
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o plugin.so src/health/plugin.go
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o health-initpanic.so src/health/initpanic.go
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -o health src/health/main.go
+./health
+
+# Test capturing the output of plugin init functions
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o plugin.so src/initoutput/plugin.go
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -o initoutput src/initoutput/main.go
+for mode in "" defer skip; do
+	output=$(./initoutput $mode 2>&1)
+	if test "$output" != "PASS"; then
+		echo "initoutput $mode: unexpected output: $output"
+		exit 1
+	fi
+done
+
+# Test OpenAll
+mkdir -p openall
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o=openall/a.so unnamed1/main.go
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o=openall/b.DLL unnamed2/main.go
+echo "not a plugin" > openall/c.so
+echo "not a plugin" > openall/README
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -o openall/openall src/openall/main.go
+./openall/openall
+
+# Test opening a plugin without running its init functions
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o plugin.so src/skipinit/plugin.go
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -o skipinit src/skipinit/main.go
+./skipinit
+
+# Test running init functions on first lookup
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o plugin.so src/deferinit/plugin.go
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -o deferinit src/deferinit/main.go
+./deferinit
+
+# Test the message bus
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o plugin.so src/bus/plugin.go
+GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -o bus src/bus/main.go
+./bus
+
 # Test for issue 22295
 GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -buildmode=plugin -o issue.22295.so issue22295.pkg
 GOPATH=$(pwd) go build -gcflags "$GO_GCFLAGS" -o issue22295 src/issue22295.pkg/main.go
@@ -0,0 +1,86 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plugin
+
+import (
+	"errors"
+	"reflect"
+)
+
+// Bind sets the fields of the struct pointed to by v to the plugin
+// symbols of the same name. A field tag of the form plugin:"Name"
+// binds the field to symbol Name instead; plugin:"-" skips the field.
+// Unexported fields are skipped.
+//
+// A symbol is stored in a field if its type is assignable to the
+// field's type. Functions bind to fields of func type, and variables
+// bind to fields of pointer type, as they are returned by Lookup:
+//
+//	var api struct {
+//		F func() int
+//		V *int `plugin:"Counter"`
+//	}
+//	err := p.Bind(&api)
+//
+// Bind sets every field it can. If any symbol is missing or has
+// the wrong type, Bind returns an error listing all of them.
+func (p *Plugin) Bind(v interface{}) error {
+	if v == nil {
+		return errors.New("plugin: Bind of nil")
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("plugin: Bind of non-pointer-to-struct " + reflect.TypeOf(v).String())
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	var msg string
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("plugin"); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		var problem string
+		sym, err := p.Lookup(name)
+		if err != nil {
+			problem = "symbol " + name + ": " + err.Error()
+		} else if st := reflect.TypeOf(sym); !st.AssignableTo(f.Type) {
+			problem = "symbol " + name + " has type " + st.String() + ", field " + f.Name + " has type " + f.Type.String()
+		} else {
+			rv.Field(i).Set(reflect.ValueOf(sym))
+			continue
+		}
+		if msg != "" {
+			msg += "; "
+		}
+		msg += problem
+	}
+	if msg != "" {
+		return errors.New("plugin: Bind: " + msg)
+	}
+	return nil
+}
+
+// SymbolType returns the type of the symbol named symName in plugin p,
+// which is the dynamic type of the Symbol that Lookup would return:
+// a func type for a function and a pointer type for a variable.
+// Unlike Lookup, it does not resolve the symbol in a plugin opened
+// with OpenLazy.
+func (p *Plugin) SymbolType(symName string) (reflect.Type, error) {
+	s, err := typedSymbol(p, symName)
+	if err != nil {
+		return nil, err
+	}
+	return reflect.TypeOf(s), nil
+}
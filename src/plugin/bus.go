@@ -0,0 +1,111 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// The message bus lets the host and its plugins exchange messages by
+// topic without passing channels to each other through symbols.
+// busMu guards the subscriptions to each topic. It is not held while
+// a message is sent, so that a subscriber may publish or subscribe
+// while it handles a message.
+var (
+	busMu sync.RWMutex
+	bus   map[string][]*subscription
+)
+
+type subscription struct {
+	topic string
+	ch    chan interface{}
+	done  chan struct{} // closed when cancelled
+	once  sync.Once
+
+	// mu is held for reading while sending on ch and for writing
+	// while closing it.
+	mu sync.RWMutex
+}
+
+// Subscribe returns a channel that receives the messages published
+// to topic, buffering up to n of them, and a function that cancels
+// the subscription. Cancelling closes the channel.
+//
+// If Subscribe is called by code in a plugin, the subscription is
+// cancelled when that plugin is closed for the last time.
+func Subscribe(topic string, n int) (<-chan interface{}, func()) {
+	s := &subscription{
+		topic: topic,
+		ch:    make(chan interface{}, n),
+		done:  make(chan struct{}),
+	}
+	busMu.Lock()
+	if bus == nil {
+		bus = make(map[string][]*subscription)
+	}
+	bus[topic] = append(bus[topic], s)
+	busMu.Unlock()
+
+	if pc, _, _, ok := runtime.Caller(1); ok {
+		onUnload(pc, s.cancel)
+	}
+	return s.ch, s.cancel
+}
+
+func (s *subscription) cancel() {
+	s.once.Do(func() {
+		// Release publishers blocked on s before waiting
+		// for them to finish.
+		close(s.done)
+		busMu.Lock()
+		subs := bus[s.topic]
+		for i, t := range subs {
+			if t == s {
+				bus[s.topic] = append(subs[:i:i], subs[i+1:]...)
+				break
+			}
+		}
+		busMu.Unlock()
+		s.mu.Lock()
+		close(s.ch)
+		s.mu.Unlock()
+	})
+}
+
+// send sends msg to s unless s is cancelled first.
+func (s *subscription) send(ctx context.Context, msg interface{}) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	select {
+	case <-s.done:
+		return nil // ch may be closed
+	default:
+	}
+	select {
+	case s.ch <- msg:
+	case <-s.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// Publish sends msg to every subscriber of topic. A subscriber whose
+// buffer is full holds up Publish until it receives the message, so
+// slow subscribers push back on publishers. Publish returns ctx.Err()
+// if ctx is done before every subscriber has received msg.
+func Publish(ctx context.Context, topic string, msg interface{}) error {
+	busMu.RLock()
+	subs := append([]*subscription(nil), bus[topic]...)
+	busMu.RUnlock()
+	for _, s := range subs {
+		if err := s.send(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
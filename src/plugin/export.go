@@ -0,0 +1,49 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plugin
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	exportsMu sync.Mutex
+	exports   map[string]Symbol
+)
+
+// Export makes value available to plugins under name, for them to
+// find with LookupHost. It is meant to be called by the host program,
+// usually before it opens any plugins, so that their init functions
+// can see it. Like the symbols returned by Lookup, a variable should be
+// exported as a pointer to it.
+// Export panics if name is already exported or value is nil.
+func Export(name string, value Symbol) {
+	if value == nil {
+		panic("plugin: Export " + name + " of nil value")
+	}
+	exportsMu.Lock()
+	defer exportsMu.Unlock()
+	if _, dup := exports[name]; dup {
+		panic("plugin: Export called twice for " + name)
+	}
+	if exports == nil {
+		exports = make(map[string]Symbol)
+	}
+	exports[name] = value
+}
+
+// LookupHost returns the value exported by the host program under
+// name with Export. It is meant to be called by plugins, which share
+// the host's copy of this package.
+// It reports an error if nothing has been exported under name.
+func LookupHost(name string) (Symbol, error) {
+	exportsMu.Lock()
+	defer exportsMu.Unlock()
+	if v, ok := exports[name]; ok {
+		return v, nil
+	}
+	return nil, errors.New("plugin: symbol " + name + " not exported by host")
+}
@@ -0,0 +1,45 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNoHealthCheck is returned by Health if the plugin does not
+// export a HealthCheck function.
+var ErrNoHealthCheck = errors.New("plugin: no HealthCheck function")
+
+// Health reports whether plugin p is healthy by calling the function
+//
+//	func HealthCheck(context.Context) error
+//
+// that the plugin may export. It returns ErrNoHealthCheck if p exports
+// no HealthCheck symbol, and an error if the symbol has another type.
+// If HealthCheck panics, Health returns a *PanicError. If ctx is done
+// before HealthCheck returns, Health returns ctx.Err() without waiting
+// for it.
+func (p *Plugin) Health(ctx context.Context) error {
+	if _, err := typedSymbol(p, "HealthCheck"); err != nil {
+		if isClosed(p) {
+			return err
+		}
+		return ErrNoHealthCheck
+	}
+	sym, err := p.Lookup("HealthCheck")
+	if err != nil {
+		return err
+	}
+	check, ok := sym.(func(context.Context) error)
+	if !ok {
+		return errors.New("plugin: HealthCheck in plugin " + p.pluginpath + " is not a func(context.Context) error")
+	}
+	errc := make(chan error, 1)
+	if err := callPlugin(ctx, func() { errc <- check(ctx) }); err != nil {
+		return err
+	}
+	return <-errc
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plugin
+
+import "sync"
+
+// Hooks are functions called by the plugin package as plugins are
+// opened, used, and closed. Any of the functions may be nil.
+// The functions may be called concurrently by multiple goroutines.
+type Hooks struct {
+	// OnLoad is called by Open with the canonical path of the
+	// plugin file, before the file is loaded or found among the
+	// plugins already open. If OnLoad returns an error, Open fails
+	// with that error.
+	OnLoad func(path string) error
+
+	// OnUnload is called after Close has released the last
+	// reference to a plugin.
+	OnUnload func(p *Plugin)
+
+	// OnLookup is called after each Lookup with the symbol name
+	// and the error Lookup returns, if any.
+	OnLookup func(p *Plugin, symName string, err error)
+}
+
+var (
+	hooksMu sync.Mutex
+	hooks   []Hooks
+)
+
+// RegisterHooks adds h to the hooks called for all plugins.
+// Hooks are called in the order they were registered.
+func RegisterHooks(h Hooks) {
+	hooksMu.Lock()
+	hooks = append(hooks, h)
+	hooksMu.Unlock()
+}
+
+func registeredHooks() []Hooks {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	return hooks[:len(hooks):len(hooks)]
+}
+
+// runLoadHooks calls the OnLoad hooks and returns the first error.
+func runLoadHooks(path string) error {
+	for _, h := range registeredHooks() {
+		if h.OnLoad != nil {
+			if err := h.OnLoad(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func runUnloadHooks(p *Plugin) {
+	for _, h := range registeredHooks() {
+		if h.OnUnload != nil {
+			h.OnUnload(p)
+		}
+	}
+}
+
+func runLookupHooks(p *Plugin, symName string, err error) {
+	for _, h := range registeredHooks() {
+		if h.OnLookup != nil {
+			h.OnLookup(p, symName, err)
+		}
+	}
+}
+
+var (
+	policyMu sync.Mutex
+	policy   func(PluginInfo) error
+)
+
+// SetPolicy sets the function consulted by every Open before the
+// plugin file is loaded or found among the plugins already open.
+// It is called after the path is resolved and after any
+// OpenOptions.SHA256 check, with a PluginInfo whose Path is the
+// canonical path of the file and whose other fields are zero.
+// If f returns an error, Open fails with an *OpenError whose Stage
+// is "policy". SetPolicy replaces any previous policy; a nil f
+// removes it.
+func SetPolicy(f func(PluginInfo) error) {
+	policyMu.Lock()
+	policy = f
+	policyMu.Unlock()
+}
+
+// checkPolicy consults the policy set by SetPolicy, if any.
+func checkPolicy(path string) error {
+	policyMu.Lock()
+	f := policy
+	policyMu.Unlock()
+	if f == nil {
+		return nil
+	}
+	return f(PluginInfo{Path: path})
+}
@@ -0,0 +1,61 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plugin
+
+import (
+	"errors"
+	"sync"
+)
+
+// A Library is a native shared library, such as one written in C,
+// loaded by OpenC.
+type Library struct {
+	name string
+
+	mu     sync.Mutex
+	handle uintptr // 0 once closed
+}
+
+// OpenC loads the native shared library name. Unlike Open, it does not
+// register a Go module with the runtime, so it must not be used to load
+// a Go plugin. A name containing no slash is searched for by the
+// system dynamic loader, as by dlopen.
+func OpenC(name string) (*Library, error) {
+	h, err := openLibrary(name)
+	if err != nil {
+		return nil, &OpenError{Path: name, Stage: "load", Err: err, op: "OpenC"}
+	}
+	return &Library{name: name, handle: h}, nil
+}
+
+// LookupProc returns the address of the function or variable named
+// name in library l. The address can be passed to C code through cgo.
+func (l *Library) LookupProc(name string) (uintptr, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.handle == 0 {
+		return 0, errors.New("plugin: library " + l.name + " is closed")
+	}
+	addr, err := lookupProc(l.handle, name)
+	if err != nil {
+		return 0, errors.New("plugin: symbol " + name + " not found in library " + l.name + ": " + err.Error())
+	}
+	return addr, nil
+}
+
+// Close unloads library l. Addresses returned by LookupProc must
+// not be used after Close.
+func (l *Library) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.handle == 0 {
+		return errors.New("plugin: library " + l.name + " is already closed")
+	}
+	if err := closeLibrary(l.handle); err != nil {
+		return errors.New("plugin: closing library " + l.name + ": " + err.Error())
+	}
+	l.handle = 0
+	return nil
+}
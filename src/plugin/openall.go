@@ -0,0 +1,96 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plugin
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// OpenAll opens every plugin file in directory dir, that is every
+// file whose name ends in .so or .dll in any letter case, with the
+// given options. The files are opened one at a time, in order of
+// file name.
+//
+// OpenAll returns the plugins that opened successfully, in order of
+// file name. If any failed, it also returns an ErrorList holding the
+// error for each of them.
+func OpenAll(dir string, opts OpenOptions) ([]*Plugin, error) {
+	return openAll(dir, opts, false)
+}
+
+// OpenAllParallel is like OpenAll, but opens the files concurrently,
+// so the init functions of different plugins may run at the same time.
+//
+// That is only safe if no two of the plugins share a package that is
+// not part of the program. The runtime initializes such a package
+// when the first plugin that imports it is opened; if a second
+// plugin's init functions reach the package while the first is still
+// initializing it, the program crashes with a "recursive call during
+// initialization" error.
+func OpenAllParallel(dir string, opts OpenOptions) ([]*Plugin, error) {
+	return openAll(dir, opts, true)
+}
+
+func openAll(dir string, opts OpenOptions, parallel bool) ([]*Plugin, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, fi := range infos {
+		if name := fi.Name(); !fi.IsDir() && pluginName(name) != name {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+
+	ps := make([]*Plugin, len(files))
+	errs := make([]error, len(files))
+	if parallel {
+		var wg sync.WaitGroup
+		for i, file := range files {
+			wg.Add(1)
+			go func(i int, file string) {
+				defer wg.Done()
+				ps[i], errs[i] = OpenWithOptions(file, opts)
+			}(i, file)
+		}
+		wg.Wait()
+	} else {
+		for i, file := range files {
+			ps[i], errs[i] = OpenWithOptions(file, opts)
+		}
+	}
+
+	var opened []*Plugin
+	var list ErrorList
+	for i, p := range ps {
+		if errs[i] != nil {
+			list = append(list, errs[i])
+		} else {
+			opened = append(opened, p)
+		}
+	}
+	if len(list) > 0 {
+		return opened, list
+	}
+	return opened, nil
+}
+
+// An ErrorList is a list of errors, one for each plugin that
+// OpenAll or OpenAllParallel could not open.
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return l[0].Error() + " (and " + strconv.Itoa(len(l)-1) + " more errors)"
+}
@@ -11,33 +11,604 @@
 //
 // When a plugin is first opened, the init functions of all packages not
 // already part of the program are called. The main function is not run.
-// A plugin is only initialized once, and cannot be closed.
+// A plugin is only initialized once. Closing a plugin releases its
+// handle, but the code and data of the plugin stay mapped in the process.
+//
+// Setting GODEBUG=pluginload=1 makes Open print a line to standard
+// error as each stage of loading a plugin completes, with the time the
+// stage took.
 //
 // Currently plugins are only supported on Linux and macOS.
 // Please report any issues.
 package plugin
 
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
 // Plugin is a loaded Go plugin.
 type Plugin struct {
 	pluginpath string
+	path       string        // canonical path
+	id         fileID        // key in plugins map
 	err        string        // set if plugin failed to load
+	stage      string        // OpenError stage at which loading failed
 	loaded     chan struct{} // closed when loaded
 	syms       map[string]interface{}
+	unresolved map[string]interface{} // symbols not yet resolved by OpenLazy
+	handle     uintptr                // OS handle of the loaded shared library
+	base       uintptr                // load address of the shared library
+	init       *pendingInit           // init functions not yet run, or nil
+	refs       int                    // number of Opens not yet matched by a Close
+	loadTime   time.Time
+}
+
+// A fileID identifies a plugin file independently of the
+// name used to open it.
+type fileID struct {
+	dev, ino uint64
+	handle   uintptr // instead of dev and ino, for a plugin loaded by OpenBytes
 }
 
 // Open opens a Go plugin.
-// If a path has already been opened, then the existing *Plugin is returned.
+// A path with no directory that does not name a file in the current
+// directory is searched for in the directories listed in the
+// GOPLUGINPATH environment variable, then in the plugins subdirectory
+// of the directory holding the executable.
+// If the file has already been opened, under this or any other name,
+// then the existing *Plugin is returned. If opening the file failed,
+// later calls report the same error, but a new file written to the
+// same path (as opposed to the old file modified in place) is loaded
+// afresh.
 // It is safe for concurrent use by multiple goroutines.
 func Open(path string) (*Plugin, error) {
-	return open(path)
+	return open(context.Background(), path, OpenOptions{})
+}
+
+// OpenContext is like Open, but gives up if ctx is done before the
+// plugin's init functions have returned. The init functions are not
+// interrupted; they keep running, but the plugin is marked as failed
+// and every later attempt to open the file reports an error.
+// If the file is being opened by another goroutine, OpenContext
+// waits for that open only until ctx is done.
+func OpenContext(ctx context.Context, path string) (*Plugin, error) {
+	return open(ctx, path, OpenOptions{})
+}
+
+// OpenOptions controls how OpenWithOptions loads a plugin.
+type OpenOptions struct {
+	// Lazy defers resolving each exported symbol of the plugin
+	// until the symbol is first looked up. See OpenLazy.
+	Lazy bool
+
+	// SHA256, if not nil, is the SHA-256 digest the plugin file
+	// must have. The digest is computed from an open handle to the
+	// file, and where the system allows it that same handle is used
+	// to load the plugin, so the file cannot be swapped in between.
+	// The check is made on every open, including opens of a file
+	// that is already loaded.
+	SHA256 []byte
+
+	// InitOutput, if not nil, receives everything written to the
+	// process's standard output and standard error while the
+	// plugin's init functions run. The redirection applies to the
+	// whole process, so output from other goroutines in that time
+	// is captured too, and plugins opened with InitOutput are
+	// initialized one at a time. With DeferInit, the writer receives
+	// the output of the init functions when the first Lookup runs
+	// them.
+	InitOutput io.Writer
+
+	// SkipInit loads the plugin and resolves its symbols without
+	// running the init functions of its Go packages, so that tools
+	// can list the exports of a plugin before deciding to run it.
+	// Only the Go init functions are skipped: the system loader
+	// still runs the shared object's constructors, among them the
+	// one that registers the Go module with the runtime and any C
+	// constructors linked in through cgo, and the plugin's symbols
+	// become visible to other libraries in the process. SkipInit is
+	// not a safe way to inspect an untrusted plugin. The returned
+	// plugin's functions must not be called. Its variables hold the
+	// values the linker gave them: a variable initialized to a
+	// constant has its value, but one whose value is computed during
+	// package initialization is still zero. A later open of the same
+	// file without SkipInit runs the init functions, once; a later
+	// open with DeferInit defers them to the first Lookup.
+	SkipInit bool
+
+	// DeferInit loads the plugin and resolves its symbols, but runs
+	// its init functions only when the first symbol is looked up, or
+	// when the same file is opened again without DeferInit or
+	// SkipInit. Concurrent first lookups run the init functions once.
+	DeferInit bool
+}
+
+// OpenWithOptions is like Open, but loads the plugin as described
+// by opts. The options only take effect when the file is loaded: if
+// it has already been opened, the existing *Plugin is returned.
+func OpenWithOptions(path string, opts OpenOptions) (*Plugin, error) {
+	return open(context.Background(), path, opts)
+}
+
+// OpenBytes opens a Go plugin from its contents instead of from a
+// file. The name is used to report errors, and as the path of the
+// plugin reported by Path and Plugins.
+//
+// The system loader can only load files, so data is written to a
+// temporary file in a directory accessible only by the current user.
+// The file is removed as soon as the plugin has been loaded.
+//
+// If a plugin with the same contents has already been opened by
+// OpenBytes, the existing *Plugin is returned. Once that plugin has
+// been closed, it cannot be opened from the same contents again.
+func OpenBytes(name string, data []byte) (*Plugin, error) {
+	sum := sha256.Sum256(data)
+	for {
+		bytesMu.Lock()
+		l := bytesLoads[sum]
+		if l == nil {
+			l = &bytesLoad{done: make(chan struct{})}
+			if bytesLoads == nil {
+				bytesLoads = make(map[[sha256.Size]byte]*bytesLoad)
+			}
+			bytesLoads[sum] = l
+			bytesMu.Unlock()
+
+			p, err := openBytes(name, data)
+			bytesMu.Lock()
+			if err != nil {
+				delete(bytesLoads, sum)
+			}
+			l.p = p
+			bytesMu.Unlock()
+			close(l.done)
+			return p, err
+		}
+		bytesMu.Unlock()
+		<-l.done
+		if l.p == nil {
+			continue // the load failed; try again
+		}
+		if !retain(l.p) {
+			return nil, &OpenError{Path: name, Stage: "load", Err: errors.New("plugin with the same contents was closed"), op: "OpenBytes"}
+		}
+		return l.p, nil
+	}
+}
+
+// openBytes loads a plugin from data through a temporary file.
+func openBytes(name string, data []byte) (*Plugin, error) {
+	dir, err := ioutil.TempDir("", "goplugin")
+	if err != nil {
+		return nil, &OpenError{Path: name, Stage: "load", Err: err, op: "OpenBytes"}
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "plugin.so")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return nil, &OpenError{Path: name, Stage: "load", Err: err, op: "OpenBytes"}
+	}
+	p, err := open(context.Background(), path, OpenOptions{})
+	detachFile(path, name)
+	if err, ok := err.(*OpenError); ok {
+		err.Path = name
+		err.op = "OpenBytes"
+	}
+	return p, err
+}
+
+// A bytesLoad is a plugin opened by OpenBytes.
+type bytesLoad struct {
+	done chan struct{} // closed when the load has finished
+	p    *Plugin       // the plugin, nil if the load failed
+}
+
+var (
+	// bytesMu guards bytesLoads.
+	bytesMu sync.Mutex
+
+	// bytesLoads maps the SHA-256 digest of the contents of every
+	// plugin opened by OpenBytes to its load. Failed loads are
+	// removed, so that a later call tries again.
+	bytesLoads map[[sha256.Size]byte]*bytesLoad
+)
+
+// OpenLazy is like Open, but it defers resolving each exported symbol
+// of the plugin until the symbol is first looked up, so the cost of
+// opening a plugin with many exports is proportional to the number of
+// symbols actually used. The plugin's init functions still run before
+// OpenLazy returns.
+// If the file has already been opened, by Open or OpenLazy, the
+// existing *Plugin is returned unchanged.
+func OpenLazy(path string) (*Plugin, error) {
+	return open(context.Background(), path, OpenOptions{Lazy: true})
+}
+
+// Close releases a reference to plugin p.
+// Every successful call to Open must be matched by a call to Close.
+// When the last reference is released the functions the plugin
+// registered with OnUnload are called, the OS handle of the plugin is
+// closed and Lookup reports an error for any symbol.
+//
+// The runtime cannot unload a Go module, so the plugin's code and data
+// remain mapped. If the same file is opened again after it has been
+// closed, the already initialized module is reused and its init
+// functions are not run a second time. For the same reason, once a
+// file has been loaded, a new file that replaces it at the same path
+// cannot be loaded in the same process, even after the plugin has
+// been closed: Open reports an error.
+func (p *Plugin) Close() error {
+	last, err := closePlugin(p)
+	if last {
+		runUnloadHooks(p)
+	}
+	return err
 }
 
 // Lookup searches for a symbol named symName in plugin p.
 // A symbol is any exported variable or function.
 // It reports an error if the symbol is not found.
 // It is safe for concurrent use by multiple goroutines.
+//
+// If p was opened with OpenOptions.DeferInit, the first Lookup runs
+// the plugin's init functions, and reports an error if they fail.
 func (p *Plugin) Lookup(symName string) (Symbol, error) {
-	return lookup(p, symName)
+	var s Symbol
+	err := p.runDeferredInit()
+	if err == nil {
+		s, err = lookup(p, symName)
+	}
+	runLookupHooks(p, symName, err)
+	return s, err
+}
+
+// runDeferredInit runs the init functions of a plugin opened with
+// OpenOptions.DeferInit, if they have not been run yet.
+func (p *Plugin) runDeferredInit() error {
+	if p.init == nil || !p.init.isDeferred() {
+		return nil
+	}
+	if err := p.init.run(context.Background(), nil); err != nil {
+		return errors.New("plugin: init of plugin " + p.pluginpath + " failed: " + err.Error())
+	}
+	return nil
+}
+
+// Path returns the canonical path of the file plugin p was loaded
+// from, with symbolic links resolved. For a plugin opened by
+// OpenBytes it returns the name passed to OpenBytes.
+func (p *Plugin) Path() string {
+	return p.path
+}
+
+// OnUnload registers f to be called when the plugin that calls
+// OnUnload is closed for the last time, before its handle is
+// released. It must be called by code in a plugin, typically from an
+// init function, and lets the plugin stop the goroutines and timers
+// and release the files it owns. Functions are called in the reverse
+// order of their registration.
+//
+// A closed plugin's init functions are not run again if it is
+// reopened, so each registered function is called at most once.
+func OnUnload(f func()) {
+	pc, _, _, ok := runtime.Caller(1)
+	if !ok {
+		panic("plugin: OnUnload cannot determine its caller")
+	}
+	if !onUnload(pc, f) {
+		panic("plugin: OnUnload called from outside an open plugin")
+	}
+}
+
+// Symbols returns the exported symbols of plugin p, keyed by name.
+// Function symbols hold the function value; variable symbols hold a
+// pointer to the variable. The returned map is a new copy on every
+// call. It is empty once p has been closed, or if p was opened with
+// OpenOptions.DeferInit and its init functions failed.
+func (p *Plugin) Symbols() map[string]Symbol {
+	if p.runDeferredInit() != nil {
+		return map[string]Symbol{}
+	}
+	return symbols(p)
+}
+
+// PluginInfo describes a plugin file that has been opened.
+type PluginInfo struct {
+	Path       string    // canonical path of the plugin file, or the name given to OpenBytes
+	PluginPath string    // import path of the plugin's main package, if known
+	Loaded     time.Time // time the file was loaded
+	Err        error     // error from loading the file, if any
+	Plugin     *Plugin   // the plugin, nil if Err is set
+}
+
+// Plugins returns information about every plugin file that is
+// currently open, and about every file that failed to load,
+// in the order they were loaded. Closed plugins are not included.
+func Plugins() []PluginInfo {
+	return registry()
+}
+
+// An OpenError records a failure to open a plugin and the stage of
+// loading at which it failed.
+type OpenError struct {
+	// Path is the path passed to Open.
+	Path string
+
+	// Stage is the stage that failed: "realpath" while resolving
+	// Path, "verify" while checking OpenOptions.SHA256, "hook" if
+	// an OnLoad hook rejected the plugin, "policy" if the policy
+	// set by SetPolicy rejected it, "load" in the system
+	// dynamic loader, "version" if the plugin was built against
+	// a different version of a package in the program, such as the
+	// runtime, "moduleinit" while registering the plugin with the
+	// runtime for any other reason, "lookup" while
+	// resolving an exported symbol, or "init" while running the
+	// plugin's init functions.
+	Stage string
+
+	// SymName is the symbol that could not be resolved
+	// when Stage is "lookup".
+	SymName string
+
+	Err error
+
+	op string // function that failed, if not Open
+}
+
+func (e *OpenError) Error() string {
+	op := e.op
+	if op == "" {
+		op = "Open"
+	}
+	s := "plugin." + op + `("` + pluginName(e.Path) + `"): `
+	if e.SymName != "" {
+		s += "could not find symbol " + e.SymName + ": "
+	}
+	return s + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *OpenError) Unwrap() error {
+	return e.Err
+}
+
+// pendingInit holds the init function of a plugin that was opened
+// without running it.
+type pendingInit struct {
+	mu       sync.Mutex
+	deferred bool      // run by the first Lookup
+	output   io.Writer // OpenOptions.InitOutput for the first Lookup
+	f        func()    // nil once run
+	err      error     // result of running f
+}
+
+// isDeferred reports whether the init function is run by the first
+// Lookup.
+func (pi *pendingInit) isDeferred() bool {
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+	return pi.deferred
+}
+
+// setDeferred makes the first Lookup run the init function, sending
+// its output to w if w is not nil, for a plugin opened with SkipInit
+// and then again with DeferInit.
+func (pi *pendingInit) setDeferred(w io.Writer) {
+	pi.mu.Lock()
+	pi.deferred = true
+	if w != nil {
+		pi.output = w
+	}
+	pi.mu.Unlock()
+}
+
+// run runs the pending init function, if it has not been run yet,
+// and returns its result. The output of the init function goes to w,
+// or if w is nil to the writer given when the init was deferred.
+func (pi *pendingInit) run(ctx context.Context, w io.Writer) error {
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+	if pi.f != nil {
+		if err := initPanicError(); err != nil {
+			return err
+		}
+		if w == nil {
+			w = pi.output
+		}
+		f := pi.f
+		pi.f = nil
+		pi.err = runInit(ctx, f, w)
+	}
+	return pi.err
+}
+
+// guardInit returns a function that calls f, the init function of the
+// plugin pluginpath, and records it if f does not return.
+func guardInit(pluginpath string, f func()) func() {
+	return func() {
+		ok := false
+		defer func() {
+			if !ok {
+				initPanicMu.Lock()
+				if initPanic == "" {
+					initPanic = pluginpath
+				}
+				initPanicMu.Unlock()
+			}
+		}()
+		f()
+		ok = true
+	}
+}
+
+// initPanicError returns the error reported by an attempt to
+// initialize a plugin after the init functions of another plugin
+// panicked, or nil if none has.
+func initPanicError() error {
+	initPanicMu.Lock()
+	defer initPanicMu.Unlock()
+	if initPanic == "" {
+		return nil
+	}
+	return errors.New("init of plugin " + initPanic + " panicked earlier; no more plugins can be initialized")
+}
+
+var (
+	// initPanicMu guards initPanic.
+	initPanicMu sync.Mutex
+
+	// initPanic is the plugin path of the first plugin whose init
+	// functions panicked. See PanicError.
+	initPanic string
+)
+
+// callPlugin calls f, which runs code in a plugin such as its init
+// function. It returns early with ctx.Err() if ctx is done before f
+// returns. A panic in f is recovered and returned as a *PanicError.
+func callPlugin(ctx context.Context, f func()) error {
+	if ctx.Done() == nil {
+		return callRecover(f)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- callRecover(f)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// callRecover calls f, converting a panic into a *PanicError.
+func callRecover(f func()) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			buf := make([]byte, 64<<10)
+			buf = buf[:runtime.Stack(buf, false)]
+			err = &PanicError{Value: v, Stack: buf}
+		}
+	}()
+	f()
+	return nil
+}
+
+// PanicError is the error returned when plugin code panics: wrapped
+// in an *OpenError when a plugin's package initialization panics, or
+// by Health when a plugin's HealthCheck panics.
+//
+// The runtime cannot recover from a panic in package initialization:
+// the packages being initialized stay marked as in progress, and a
+// later plugin that imports one of them would crash the program. So
+// once a plugin's init functions have panicked, no more plugins are
+// loaded and no pending init functions are run; Open and Lookup
+// report an error instead.
+type PanicError struct {
+	Value interface{} // value passed to panic
+	Stack []byte      // stack trace of the panicking goroutine
+}
+
+func (e *PanicError) Error() string {
+	switch v := e.Value.(type) {
+	case error:
+		return "panic: " + v.Error()
+	case stringer:
+		return "panic: " + v.String()
+	case string:
+		return "panic: " + v
+	}
+	return "panic in plugin code"
+}
+
+type stringer interface {
+	String() string
+}
+
+// verifySHA256 reports an error if the SHA-256 digest
+// of the contents of r is not want.
+func verifySHA256(r io.Reader, want []byte) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	got := h.Sum(nil)
+	if len(got) != len(want) {
+		return errors.New("SHA-256 digest mismatch")
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return errors.New("SHA-256 digest mismatch")
+		}
+	}
+	return nil
+}
+
+// findPlugin returns the file that Open loads for name. A name with
+// no directory that does not name a file in the current directory is
+// searched for in the directories listed in the GOPLUGINPATH
+// environment variable, and then in the plugins subdirectory of the
+// directory holding the executable. If the search finds nothing,
+// name is returned unchanged.
+func findPlugin(name string) string {
+	if filepath.Base(name) != name {
+		return name
+	}
+	if _, err := os.Stat(name); err == nil {
+		return name
+	}
+	dirs := filepath.SplitList(os.Getenv("GOPLUGINPATH"))
+	if exe, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Join(filepath.Dir(exe), "plugins"))
+	}
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			return path
+		}
+	}
+	return name
+}
+
+// pluginName returns the name of the plugin at path for use in
+// messages, which is path without its .so or .dll extension.
+// The extension is matched without regard to case.
+func pluginName(path string) string {
+	for _, ext := range [...]string{".so", ".dll"} {
+		if n := len(path) - len(ext); n > 0 && equalFoldASCII(path[n:], ext) {
+			return path[:n]
+		}
+	}
+	return path
+}
+
+// equalFoldASCII reports whether s and t, which must be the same
+// length, are equal under ASCII case folding.
+func equalFoldASCII(s, t string) bool {
+	for i := 0; i < len(s); i++ {
+		a, b := s[i], t[i]
+		if 'A' <= a && a <= 'Z' {
+			a += 'a' - 'A'
+		}
+		if 'A' <= b && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+		if a != b {
+			return false
+		}
+	}
+	return true
 }
 
 // A Symbol is a pointer to a variable or function.
@@ -8,21 +8,80 @@ package plugin
 
 /*
 #cgo linux LDFLAGS: -ldl
+#ifdef __linux__
+#define _GNU_SOURCE // for dl_iterate_phdr
+#include <link.h>
+#include <string.h>
+#endif
 #include <dlfcn.h>
 #include <limits.h>
 #include <stdlib.h>
 #include <stdint.h>
+#include <sys/stat.h>
 
 #include <stdio.h>
+#include <unistd.h>
 
 static uintptr_t pluginOpen(const char* path, char** err) {
-	void* h = dlopen(path, RTLD_NOW|RTLD_GLOBAL);
+	// RTLD_NODELETE keeps the module mapped after dlclose:
+	// the runtime holds on to its moduledata until exit.
+	void* h = dlopen(path, RTLD_NOW|RTLD_GLOBAL|RTLD_NODELETE);
 	if (h == NULL) {
 		*err = (char*)dlerror();
 	}
 	return (uintptr_t)h;
 }
 
+// pluginOpenC opens a native shared library, which the process
+// may unload again when it is closed.
+static uintptr_t pluginOpenC(const char* path, char** err) {
+	void* h = dlopen(path, RTLD_NOW|RTLD_LOCAL);
+	if (h == NULL) {
+		*err = (char*)dlerror();
+	}
+	return (uintptr_t)h;
+}
+
+#ifdef __linux__
+static int pluginNameLoaded(struct dl_phdr_info* info, size_t size, void* name) {
+	return strcmp(info->dlpi_name, (const char*)name) == 0;
+}
+#endif
+
+// pluginOpenFD opens the file open as fd, whose name is path.
+// On Linux the file is loaded through /proc so that the loader
+// sees exactly the file behind fd, even if path now names another.
+static uintptr_t pluginOpenFD(int fd, const char* path, char** err) {
+#ifdef __linux__
+	// The loader matches names before it looks at files, and an
+	// earlier load may have used the same descriptor number.
+	// Duplicate fd until its /proc name is not in use.
+	char buf[64];
+	int dups[16];
+	int ndup = 0;
+	uintptr_t h;
+
+	for (;;) {
+		snprintf(buf, sizeof buf, "/proc/self/fd/%d", fd);
+		if (!dl_iterate_phdr(pluginNameLoaded, buf)) {
+			path = buf;
+			break;
+		}
+		if (ndup == sizeof dups / sizeof dups[0] || (fd = dup(fd)) < 0) {
+			break;
+		}
+		dups[ndup++] = fd;
+	}
+	h = pluginOpen(path, err);
+	while (ndup > 0) {
+		close(dups[--ndup]);
+	}
+	return h;
+#else
+	return pluginOpen(path, err);
+#endif
+}
+
 static void* pluginLookup(uintptr_t h, const char* name, char** err) {
 	void* r = dlsym((void*)h, name);
 	if (r == NULL) {
@@ -30,12 +89,83 @@ static void* pluginLookup(uintptr_t h, const char* name, char** err) {
 	}
 	return r;
 }
+
+// pluginFileID reports the identity of the file at path, which
+// is the same for every name that refers to it.
+static int pluginFileID(const char* path, uint64_t* dev, uint64_t* ino) {
+	struct stat st;
+	if (stat(path, &st) != 0) {
+		return -1;
+	}
+	*dev = (uint64_t)st.st_dev;
+	*ino = (uint64_t)st.st_ino;
+	return 0;
+}
+
+static int pluginFDFileID(int fd, uint64_t* dev, uint64_t* ino) {
+	struct stat st;
+	if (fstat(fd, &st) != 0) {
+		return -1;
+	}
+	*dev = (uint64_t)st.st_dev;
+	*ino = (uint64_t)st.st_ino;
+	return 0;
+}
+
+// pluginBase returns the address at which the shared object
+// containing addr is loaded.
+static uintptr_t pluginBase(uintptr_t addr) {
+	Dl_info info;
+	if (dladdr((void*)addr, &info) == 0) {
+		return 0;
+	}
+	return (uintptr_t)info.dli_fbase;
+}
+
+// pluginRedirect points standard output and standard error at fd,
+// saving the old descriptors in saved.
+static int pluginRedirect(int fd, int* saved) {
+	fflush(stdout);
+	fflush(stderr);
+	saved[0] = dup(1);
+	saved[1] = dup(2);
+	if (saved[0] < 0 || saved[1] < 0 || dup2(fd, 1) < 0 || dup2(fd, 2) < 0) {
+		return -1;
+	}
+	return 0;
+}
+
+// pluginRestore undoes pluginRedirect.
+static void pluginRestore(int* saved) {
+	fflush(stdout);
+	fflush(stderr);
+	if (saved[0] >= 0) {
+		dup2(saved[0], 1);
+		close(saved[0]);
+	}
+	if (saved[1] >= 0) {
+		dup2(saved[1], 2);
+		close(saved[1]);
+	}
+}
+
+static int pluginClose(uintptr_t h, char** err) {
+	if (dlclose((void*)h) != 0) {
+		*err = (char*)dlerror();
+		return -1;
+	}
+	return 0;
+}
 */
 import "C"
 
 import (
+	"context"
 	"errors"
+	"io"
+	"os"
 	"sync"
+	"time"
 	"unsafe"
 )
 
@@ -49,114 +179,675 @@ func lastIndexByte(s string, c byte) int {
 	return -1
 }
 
-func open(name string) (*Plugin, error) {
+// moduleinitStage returns the OpenError stage for the error errstr
+// from lastmoduleinit. The runtime compares the hash of every package
+// the plugin was linked against with the package in the host before
+// any plugin code runs; a mismatch is reported as stage "version".
+func moduleinitStage(errstr string) string {
+	const mismatch = "plugin was built with a different version of package "
+	if len(errstr) >= len(mismatch) && errstr[:len(mismatch)] == mismatch {
+		return "version"
+	}
+	return "moduleinit"
+}
+
+func open(ctx context.Context, name string, opts OpenOptions) (*Plugin, error) {
+	t := newLoadTrace(name)
+	p, err := load(ctx, name, opts, t)
+	t.done(err)
+	return p, err
+}
+
+func load(ctx context.Context, name string, opts OpenOptions, t *loadTrace) (*Plugin, error) {
 	cPath := make([]byte, C.PATH_MAX+1)
-	cRelName := make([]byte, len(name)+1)
-	copy(cRelName, name)
+	relName := findPlugin(name)
+	cRelName := make([]byte, len(relName)+1)
+	copy(cRelName, relName)
 	if C.realpath(
 		(*C.char)(unsafe.Pointer(&cRelName[0])),
 		(*C.char)(unsafe.Pointer(&cPath[0]))) == nil {
-		return nil, errors.New(`plugin.Open("` + name + `"): realpath failed`)
+		return nil, &OpenError{Path: name, Stage: "realpath", Err: errors.New("realpath failed")}
 	}
 
 	filepath := C.GoString((*C.char)(unsafe.Pointer(&cPath[0])))
 
+	// realpath does not fold case on case-insensitive file systems
+	// and leaves hard links distinct, so key plugins on the identity
+	// of the file rather than on its name.
+	var dev, ino C.uint64_t
+	var f *os.File
+	if opts.SHA256 != nil {
+		// Hash an open file, and later load that same file,
+		// so that it cannot be replaced after it is verified.
+		var err error
+		f, err = os.Open(filepath)
+		if err != nil {
+			return nil, &OpenError{Path: name, Stage: "verify", Err: err}
+		}
+		defer f.Close()
+		if C.pluginFDFileID(C.int(f.Fd()), &dev, &ino) != 0 {
+			return nil, &OpenError{Path: name, Stage: "realpath", Err: errors.New("stat failed")}
+		}
+	} else if C.pluginFileID((*C.char)(unsafe.Pointer(&cPath[0])), &dev, &ino) != 0 {
+		return nil, &OpenError{Path: name, Stage: "realpath", Err: errors.New("stat failed")}
+	}
+	id := fileID{dev: uint64(dev), ino: uint64(ino)}
+	t.stage("realpath")
+	if err := runLoadHooks(filepath); err != nil {
+		return nil, &OpenError{Path: name, Stage: "hook", Err: err}
+	}
+	if f != nil {
+		if err := verifySHA256(f, opts.SHA256); err != nil {
+			return nil, &OpenError{Path: name, Stage: "verify", Err: err}
+		}
+		t.stage("verify")
+	}
+	if err := checkPolicy(filepath); err != nil {
+		return nil, &OpenError{Path: name, Stage: "policy", Err: err}
+	}
+
 	pluginsMu.Lock()
-	if p := plugins[filepath]; p != nil {
+	if p := plugins[id]; p != nil {
+		if p.err != "" {
+			pluginsMu.Unlock()
+			return nil, &OpenError{Path: name, Stage: p.stage, Err: errors.New(p.err + " (previous failure)")}
+		}
+		p.refs++
 		pluginsMu.Unlock()
+		select {
+		case <-p.loaded:
+		case <-ctx.Done():
+			release(p)
+			return nil, &OpenError{Path: name, Stage: "init", Err: ctx.Err()}
+		}
 		if p.err != "" {
-			return nil, errors.New(`plugin.Open("` + name + `"): ` + p.err + ` (previous failure)`)
+			return nil, &OpenError{Path: name, Stage: p.stage, Err: errors.New(p.err)}
+		}
+		if p.init != nil && opts.DeferInit {
+			p.init.setDeferred(opts.InitOutput)
+		} else if p.init != nil && !opts.SkipInit {
+			if err := p.init.run(ctx, opts.InitOutput); err != nil {
+				release(p)
+				return nil, &OpenError{Path: name, Stage: "init", Err: err}
+			}
 		}
-		<-p.loaded
+		t.stage("cached")
 		return p, nil
 	}
+	if err := initPanicError(); err != nil {
+		// The new plugin's init functions could run into a
+		// package left half initialized.
+		pluginsMu.Unlock()
+		return nil, &OpenError{Path: name, Stage: "moduleinit", Err: err}
+	}
+	if plugins == nil {
+		plugins = make(map[fileID]*Plugin)
+		handles = make(map[uintptr]*Plugin)
+	}
+	// Drop a placeholder in the map so concurrent opens of the same
+	// file, including opens from the init function of the plugin,
+	// wait on it rather than load the file a second time. Opens of
+	// other files proceed while this one loads.
+	p := &Plugin{
+		path:     filepath,
+		id:       id,
+		loaded:   make(chan struct{}),
+		refs:     1,
+		loadTime: time.Now(),
+	}
+	plugins[id] = p
+	pluginsMu.Unlock()
+
+	// The runtime finds a new module as the last one in its list, so
+	// loading a file and registering its module with lastmoduleinit
+	// must not overlap with another load.
+	loadMu.Lock()
 	var cErr *C.char
-	h := C.pluginOpen((*C.char)(unsafe.Pointer(&cPath[0])), &cErr)
+	var h C.uintptr_t
+	if f != nil {
+		h = C.pluginOpenFD(C.int(f.Fd()), (*C.char)(unsafe.Pointer(&cPath[0])), &cErr)
+	} else {
+		h = C.pluginOpen((*C.char)(unsafe.Pointer(&cPath[0])), &cErr)
+	}
 	if h == 0 {
+		loadMu.Unlock()
+		errstr := C.GoString(cErr)
+		abandonLoad(p, "load", errstr)
+		return nil, &OpenError{Path: name, Stage: "load", Err: errors.New(errstr)}
+	}
+	t.stage("dlopen")
+	pluginsMu.Lock()
+	if r := resident[uintptr(h)]; r != nil {
+		if r.id != id {
+			// The loader matched the name of a closed plugin,
+			// but the file has since been replaced. The old
+			// module cannot be unloaded, so the new file
+			// cannot be loaded.
+			pluginsMu.Unlock()
+			C.pluginClose(h, &cErr)
+			loadMu.Unlock()
+			errstr := "plugin already loaded: plugin " + r.pluginpath + " was closed and its file replaced"
+			abandonLoad(p, "moduleinit", errstr)
+			return nil, &OpenError{Path: name, Stage: "moduleinit", Err: errors.New(errstr)}
+		}
+		// The file was opened and closed before. Its module is
+		// still registered with the runtime, so reuse it.
+		delete(resident, uintptr(h))
+		p.pluginpath = r.pluginpath
+		p.syms = r.syms
+		p.unresolved = r.unresolved
+		p.handle = uintptr(h)
+		p.base = r.base
+		p.init = r.init
+		handles[p.handle] = p
+		pluginsMu.Unlock()
+		loadMu.Unlock()
+		close(p.loaded)
+		if p.init != nil && opts.DeferInit {
+			p.init.setDeferred(opts.InitOutput)
+		} else if p.init != nil && !opts.SkipInit {
+			if err := p.init.run(ctx, opts.InitOutput); err != nil {
+				release(p)
+				return nil, &OpenError{Path: name, Stage: "init", Err: err}
+			}
+		}
+		return p, nil
+	}
+	if q := handles[uintptr(h)]; q != nil && q.err != "" && f == nil {
+		// The loader matched the name of a file that failed to load
+		// before, but a new file has since replaced it. Load the new
+		// file through a descriptor, so it is not matched by name.
 		pluginsMu.Unlock()
-		return nil, errors.New(`plugin.Open("` + name + `"): ` + C.GoString(cErr))
+		C.pluginClose(h, &cErr)
+		var err error
+		if f, err = os.Open(filepath); err != nil {
+			loadMu.Unlock()
+			abandonLoad(p, "load", err.Error())
+			return nil, &OpenError{Path: name, Stage: "load", Err: err}
+		}
+		defer f.Close()
+		h = C.pluginOpenFD(C.int(f.Fd()), (*C.char)(unsafe.Pointer(&cPath[0])), &cErr)
+		if h == 0 {
+			loadMu.Unlock()
+			errstr := C.GoString(cErr)
+			abandonLoad(p, "load", errstr)
+			return nil, &OpenError{Path: name, Stage: "load", Err: errors.New(errstr)}
+		}
+		pluginsMu.Lock()
 	}
+	if q := handles[uintptr(h)]; q != nil {
+		// The module is already registered with the runtime, so
+		// lastmoduleinit must not see it again.
+		pluginsMu.Unlock()
+		C.pluginClose(h, &cErr)
+		loadMu.Unlock()
+		errstr, stage := "plugin already loaded", "moduleinit"
+		if q.err != "" {
+			errstr, stage = q.err+" (previous failure)", q.stage
+		}
+		abandonLoad(p, stage, errstr)
+		return nil, &OpenError{Path: name, Stage: stage, Err: errors.New(errstr)}
+	}
+	pluginsMu.Unlock()
 	// TODO(crawshaw): look for plugin note, confirm it is a Go plugin
 	// and it was built with the correct toolchain.
-	if len(name) > 3 && name[len(name)-3:] == ".so" {
-		name = name[:len(name)-3]
-	}
-	if plugins == nil {
-		plugins = make(map[string]*Plugin)
-	}
 	pluginpath, syms, errstr := lastmoduleinit()
 	if errstr != "" {
-		plugins[filepath] = &Plugin{
-			pluginpath: pluginpath,
-			err:        errstr,
+		// Keep the failed entry, so later opens of the file
+		// report the error instead of loading it again.
+		pluginsMu.Lock()
+		if errstr == "plugin already loaded" {
+			for _, q := range plugins {
+				if q.pluginpath == pluginpath && q.err == "" && q != p {
+					errstr += ": plugin path " + pluginpath + " is in use by " + q.path
+					break
+				}
+			}
 		}
+		p.pluginpath = pluginpath
+		p.handle = uintptr(h)
+		p.err = errstr
+		p.stage = moduleinitStage(errstr)
+		p.refs = 0
+		handles[p.handle] = p
 		pluginsMu.Unlock()
-		return nil, errors.New(`plugin.Open("` + name + `"): ` + errstr)
-	}
-	// This function can be called from the init function of a plugin.
-	// Drop a placeholder in the map so subsequent opens can wait on it.
-	p := &Plugin{
-		pluginpath: pluginpath,
-		loaded:     make(chan struct{}),
+		loadMu.Unlock()
+		close(p.loaded)
+		return nil, &OpenError{Path: name, Stage: p.stage, Err: errors.New(errstr)}
 	}
-	plugins[filepath] = p
-	pluginsMu.Unlock()
+	t.stage("moduleinit")
 
 	initStr := make([]byte, len(pluginpath)+6)
 	copy(initStr, pluginpath)
 	copy(initStr[len(pluginpath):], ".init")
-
 	initFuncPC := C.pluginLookup(h, (*C.char)(unsafe.Pointer(&initStr[0])), &cErr)
+
+	pluginsMu.Lock()
+	p.pluginpath = pluginpath
+	p.handle = uintptr(h)
+	if initFuncPC != nil {
+		p.base = uintptr(C.pluginBase(C.uintptr_t(uintptr(initFuncPC))))
+	}
+	handles[p.handle] = p
+	pluginsMu.Unlock()
+	loadMu.Unlock()
+
 	if initFuncPC != nil {
 		initFuncP := &initFuncPC
-		initFunc := *(*func())(unsafe.Pointer(&initFuncP))
-		initFunc()
+		initFunc := guardInit(pluginpath, *(*func())(unsafe.Pointer(&initFuncP)))
+		if opts.SkipInit || opts.DeferInit {
+			p.init = &pendingInit{f: initFunc, deferred: opts.DeferInit}
+			if opts.DeferInit {
+				p.init.output = opts.InitOutput
+			}
+		} else if err := runInit(ctx, initFunc, opts.InitOutput); err != nil {
+			failLoad(p, "init", "init did not complete: "+err.Error())
+			return nil, &OpenError{Path: name, Stage: "init", Err: err}
+		}
 	}
+	t.stage("init")
 
-	// Fill out the value of each plugin symbol.
-	updatedSyms := map[string]interface{}{}
-	for symName, sym := range syms {
-		isFunc := symName[0] == '.'
-		if isFunc {
-			delete(syms, symName)
-			symName = symName[1:]
+	if opts.Lazy {
+		p.syms = make(map[string]interface{})
+		p.unresolved = syms
+	} else {
+		// Fill out the value of each plugin symbol.
+		updatedSyms := make(map[string]interface{}, len(syms))
+		for symName, sym := range syms {
+			symName, sym, err := resolve(h, pluginpath, symName, sym)
+			if err != nil {
+				failLoad(p, "lookup", "could not find symbol "+symName+": "+err.Error())
+				return nil, &OpenError{Path: name, Stage: "lookup", SymName: symName, Err: err}
+			}
+			updatedSyms[symName] = sym
 		}
+		p.syms = updatedSyms
+		t.stage("symbols")
+	}
 
-		fullName := pluginpath + "." + symName
-		cname := make([]byte, len(fullName)+1)
-		copy(cname, fullName)
+	close(p.loaded)
+	return p, nil
+}
 
-		p := C.pluginLookup(h, (*C.char)(unsafe.Pointer(&cname[0])), &cErr)
-		if p == nil {
-			return nil, errors.New(`plugin.Open("` + name + `"): could not find symbol ` + symName + `: ` + C.GoString(cErr))
-		}
-		valp := (*[2]unsafe.Pointer)(unsafe.Pointer(&sym))
-		if isFunc {
-			(*valp)[1] = unsafe.Pointer(&p)
-		} else {
-			(*valp)[1] = p
+// runInit calls the init function of a plugin, sending the output
+// it writes to w if w is not nil.
+func runInit(ctx context.Context, initFunc func(), w io.Writer) error {
+	if w != nil {
+		restore, err := redirectOutput(w)
+		if err != nil {
+			return err
 		}
-		// we can't add to syms during iteration as we'll end up processing
-		// some symbols twice with the inability to tell if the symbol is a function
-		updatedSyms[symName] = sym
+		defer restore()
 	}
-	p.syms = updatedSyms
+	return callPlugin(ctx, initFunc)
+}
 
+// redirectOutput sends the process's standard output and standard
+// error to w until the returned function is called.
+func redirectOutput(w io.Writer) (restore func(), err error) {
+	r, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	outputMu.Lock()
+	var saved [2]C.int
+	if C.pluginRedirect(C.int(pw.Fd()), &saved[0]) != 0 {
+		C.pluginRestore(&saved[0])
+		outputMu.Unlock()
+		r.Close()
+		pw.Close()
+		return nil, errors.New("cannot redirect standard output")
+	}
+	pw.Close()
+	done := make(chan struct{})
+	go func() {
+		io.Copy(w, r)
+		r.Close()
+		close(done)
+	}()
+	return func() {
+		C.pluginRestore(&saved[0])
+		<-done
+		outputMu.Unlock()
+	}, nil
+}
+
+// abandonLoad removes p, whose file could not be loaded, from the
+// plugins map and wakes any opens waiting for it. Unlike failLoad, it
+// leaves no record of the failure, so a later open tries again.
+func abandonLoad(p *Plugin, stage, errstr string) {
+	pluginsMu.Lock()
+	if plugins[p.id] == p {
+		delete(plugins, p.id)
+	}
+	p.err = errstr
+	p.stage = stage
+	p.refs = 0
+	pluginsMu.Unlock()
 	close(p.loaded)
-	return p, nil
+}
+
+// failLoad marks p, whose load has not completed, as failed at
+// stage and wakes any opens waiting for it.
+func failLoad(p *Plugin, stage, errstr string) {
+	pluginsMu.Lock()
+	p.err = errstr
+	p.stage = stage
+	p.refs = 0
+	pluginsMu.Unlock()
+	close(p.loaded)
+}
+
+// detachFile forgets the file at path, which an OpenBytes call has
+// just tried to load and is about to remove. If the file loaded, its
+// plugin is renamed name and keyed by its handle, so that a new file
+// that reuses the inode is not taken for it. If it failed to load, the
+// failure is dropped, since no later open can name the file.
+func detachFile(path, name string) {
+	cPath := make([]byte, len(path)+1)
+	copy(cPath, path)
+	var dev, ino C.uint64_t
+	if C.pluginFileID((*C.char)(unsafe.Pointer(&cPath[0])), &dev, &ino) != 0 {
+		return
+	}
+	id := fileID{dev: uint64(dev), ino: uint64(ino)}
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	p := plugins[id]
+	if p == nil {
+		return
+	}
+	delete(plugins, id)
+	if p.err == "" {
+		p.path = name
+		p.id = fileID{handle: p.handle}
+		plugins[p.id] = p
+	}
+}
+
+// release drops the reference to p taken by an open that then
+// failed. A failed load may already have dropped every reference.
+func release(p *Plugin) {
+	pluginsMu.Lock()
+	if p.refs > 0 {
+		p.refs--
+	}
+	pluginsMu.Unlock()
+}
+
+// retain adds a reference to p. It reports false if p is closed.
+func retain(p *Plugin) bool {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	if p.refs == 0 {
+		return false
+	}
+	p.refs++
+	return true
+}
+
+// resolve fills in the value of the symbol named key, as named in
+// the map returned by lastmoduleinit, from the plugin with handle h.
+// On entry sym carries only the type of the symbol. It returns the
+// exported name of the symbol and its value.
+func resolve(h C.uintptr_t, pluginpath, key string, sym interface{}) (string, interface{}, error) {
+	symName := key
+	isFunc := symName[0] == '.'
+	if isFunc {
+		symName = symName[1:]
+	}
+
+	fullName := pluginpath + "." + symName
+	cname := make([]byte, len(fullName)+1)
+	copy(cname, fullName)
+
+	var cErr *C.char
+	p := C.pluginLookup(h, (*C.char)(unsafe.Pointer(&cname[0])), &cErr)
+	if p == nil {
+		return symName, nil, errors.New(C.GoString(cErr))
+	}
+	valp := (*[2]unsafe.Pointer)(unsafe.Pointer(&sym))
+	if isFunc {
+		(*valp)[1] = unsafe.Pointer(&p)
+	} else {
+		(*valp)[1] = p
+	}
+	return symName, sym, nil
+}
+
+// resolveLazy resolves symName in a plugin opened with OpenLazy
+// and caches the result. It reports false if p exports no such
+// symbol. pluginsMu must be held.
+func resolveLazy(p *Plugin, symName string) (interface{}, bool, error) {
+	if symName == "" || symName[0] == '.' {
+		return nil, false, nil
+	}
+	key := symName
+	sym, ok := p.unresolved[key]
+	if !ok {
+		key = "." + symName
+		sym, ok = p.unresolved[key]
+	}
+	if !ok {
+		return nil, false, nil
+	}
+	_, sym, err := resolve(C.uintptr_t(p.handle), p.pluginpath, key, sym)
+	if err != nil {
+		return nil, true, err
+	}
+	delete(p.unresolved, key)
+	p.syms[symName] = sym
+	return sym, true, nil
 }
 
 func lookup(p *Plugin, symName string) (Symbol, error) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	if p.refs == 0 {
+		return nil, errors.New("plugin: plugin " + p.pluginpath + " is closed")
+	}
+	if s := p.syms[symName]; s != nil {
+		return s, nil
+	}
+	s, ok, err := resolveLazy(p, symName)
+	if err != nil {
+		return nil, errors.New("plugin: could not find symbol " + symName + " in plugin " + p.pluginpath + ": " + err.Error())
+	}
+	if ok {
+		return s, nil
+	}
+	return nil, errors.New("plugin: symbol " + symName + " not found in plugin " + p.pluginpath)
+}
+
+func registry() []PluginInfo {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	infos := make([]PluginInfo, 0, len(plugins))
+	for _, p := range plugins {
+		info := PluginInfo{
+			Path:       p.path,
+			PluginPath: p.pluginpath,
+			Loaded:     p.loadTime,
+		}
+		if p.err != "" {
+			info.Err = errors.New(p.err)
+		} else {
+			select {
+			case <-p.loaded:
+				info.Plugin = p
+			default:
+				continue // still initializing
+			}
+		}
+		// Insert in order of load time.
+		i := len(infos)
+		infos = append(infos, info)
+		for ; i > 0 && infos[i-1].Loaded.After(info.Loaded); i-- {
+			infos[i] = infos[i-1]
+		}
+		infos[i] = info
+	}
+	return infos
+}
+
+func isClosed(p *Plugin) bool {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	return p.refs == 0
+}
+
+func typedSymbol(p *Plugin, symName string) (interface{}, error) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	if p.refs == 0 {
+		return nil, errors.New("plugin: plugin " + p.pluginpath + " is closed")
+	}
 	if s := p.syms[symName]; s != nil {
 		return s, nil
 	}
+	if symName != "" && symName[0] != '.' {
+		if s, ok := p.unresolved[symName]; ok {
+			return s, nil
+		}
+		if s, ok := p.unresolved["."+symName]; ok {
+			return s, nil
+		}
+	}
 	return nil, errors.New("plugin: symbol " + symName + " not found in plugin " + p.pluginpath)
 }
 
+func symbols(p *Plugin) map[string]Symbol {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	for key := range p.unresolved {
+		if key[0] == '.' {
+			key = key[1:]
+		}
+		resolveLazy(p, key)
+	}
+	syms := make(map[string]Symbol, len(p.syms))
+	for name, s := range p.syms {
+		syms[name] = s
+	}
+	return syms
+}
+
+// closePlugin releases a reference to p. It reports whether
+// that was the last reference, so that p is now closed.
+func closePlugin(p *Plugin) (last bool, err error) {
+	pluginsMu.Lock()
+	if p.refs == 0 {
+		pluginsMu.Unlock()
+		return false, errors.New("plugin: plugin " + p.pluginpath + " is already closed")
+	}
+	p.refs--
+	if p.refs > 0 {
+		pluginsMu.Unlock()
+		return false, nil
+	}
+	if resident == nil {
+		resident = make(map[uintptr]*Plugin)
+	}
+	resident[p.handle] = &Plugin{
+		pluginpath: p.pluginpath,
+		id:         p.id,
+		syms:       p.syms,
+		unresolved: p.unresolved,
+		base:       p.base,
+		init:       p.init,
+	}
+	delete(plugins, p.id)
+	delete(handles, p.handle)
+	p.syms = nil
+	p.unresolved = nil
+	fns := unloadFuncs[p.base]
+	delete(unloadFuncs, p.base)
+	pluginsMu.Unlock()
+
+	for i := len(fns) - 1; i >= 0; i-- {
+		fns[i]()
+	}
+	var cErr *C.char
+	if C.pluginClose(C.uintptr_t(p.handle), &cErr) != 0 {
+		return true, errors.New("plugin: closing plugin " + p.pluginpath + ": " + C.GoString(cErr))
+	}
+	return true, nil
+}
+
+// onUnload registers f to run when the plugin containing pc is
+// closed. It reports false if pc is not in an open plugin.
+func onUnload(pc uintptr, f func()) bool {
+	base := uintptr(C.pluginBase(C.uintptr_t(pc)))
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	for _, p := range plugins {
+		if p.base != 0 && p.base == base && p.err == "" {
+			if unloadFuncs == nil {
+				unloadFuncs = make(map[uintptr][]func())
+			}
+			unloadFuncs[base] = append(unloadFuncs[base], f)
+			return true
+		}
+	}
+	return false
+}
+
 var (
+	// outputMu serializes redirections of standard output.
+	outputMu sync.Mutex
+
+	// loadMu serializes loading files and registering their
+	// modules with the runtime. It is acquired before pluginsMu.
+	loadMu sync.Mutex
+
+	// pluginsMu guards the maps below and the mutable fields
+	// of every Plugin.
 	pluginsMu sync.Mutex
-	plugins   map[string]*Plugin
+	plugins   map[fileID]*Plugin
+
+	// handles maps the handle of every module the runtime has seen,
+	// including those that failed in lastmoduleinit, to its plugin.
+	// Closed plugins move to resident.
+	handles map[uintptr]*Plugin
+
+	// resident holds the modules of closed plugins, keyed by the
+	// handle the OS returns when the same file is opened again.
+	resident map[uintptr]*Plugin
+
+	// unloadFuncs holds the functions registered by OnUnload,
+	// keyed by the load address of the registering plugin.
+	unloadFuncs map[uintptr][]func()
 )
 
+func openLibrary(name string) (uintptr, error) {
+	cName := make([]byte, len(name)+1)
+	copy(cName, name)
+	var cErr *C.char
+	h := C.pluginOpenC((*C.char)(unsafe.Pointer(&cName[0])), &cErr)
+	if h == 0 {
+		return 0, errors.New(C.GoString(cErr))
+	}
+	return uintptr(h), nil
+}
+
+func lookupProc(h uintptr, name string) (uintptr, error) {
+	cName := make([]byte, len(name)+1)
+	copy(cName, name)
+	var cErr *C.char
+	p := C.pluginLookup(C.uintptr_t(h), (*C.char)(unsafe.Pointer(&cName[0])), &cErr)
+	if p == nil {
+		return 0, errors.New(C.GoString(cErr))
+	}
+	return uintptr(p), nil
+}
+
+func closeLibrary(h uintptr) error {
+	var cErr *C.char
+	if C.pluginClose(C.uintptr_t(h), &cErr) != 0 {
+		return errors.New(C.GoString(cErr))
+	}
+	return nil
+}
+
 // lastmoduleinit is defined in package runtime
 func lastmoduleinit() (pluginpath string, syms map[string]interface{}, errstr string)
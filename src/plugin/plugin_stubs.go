@@ -6,12 +6,62 @@
 
 package plugin
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+func runInit(ctx context.Context, initFunc func(), w io.Writer) error {
+	return callPlugin(ctx, initFunc)
+}
 
 func lookup(p *Plugin, symName string) (interface{}, error) {
 	return nil, errors.New("plugin: not implemented")
 }
 
-func open(name string) (*Plugin, error) {
+func open(ctx context.Context, name string, opts OpenOptions) (*Plugin, error) {
+	return nil, errors.New("plugin: not implemented")
+}
+
+func closePlugin(p *Plugin) (last bool, err error) {
+	return false, errors.New("plugin: not implemented")
+}
+
+func detachFile(path, name string) {}
+
+func retain(p *Plugin) bool {
+	return false
+}
+
+func registry() []PluginInfo {
+	return nil
+}
+
+func isClosed(p *Plugin) bool {
+	return true
+}
+
+func typedSymbol(p *Plugin, symName string) (interface{}, error) {
 	return nil, errors.New("plugin: not implemented")
 }
+
+func symbols(p *Plugin) map[string]Symbol {
+	return nil
+}
+
+func onUnload(pc uintptr, f func()) bool {
+	return false
+}
+
+func openLibrary(name string) (uintptr, error) {
+	return 0, errors.New("plugin: not implemented")
+}
+
+func lookupProc(h uintptr, name string) (uintptr, error) {
+	return 0, errors.New("plugin: not implemented")
+}
+
+func closeLibrary(h uintptr) error {
+	return errors.New("plugin: not implemented")
+}
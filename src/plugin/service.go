@@ -0,0 +1,79 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plugin
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+)
+
+var (
+	servicesMu sync.Mutex
+	services   map[string]interface{}
+)
+
+// ProvideService makes the service v, such as a logger or a database
+// handle, available to plugins under name. It is meant to be called
+// by the host program before it opens the plugins that need it.
+// ProvideService panics if name is already provided or v is nil.
+func ProvideService(name string, v interface{}) {
+	if v == nil {
+		panic("plugin: ProvideService " + name + " of nil value")
+	}
+	servicesMu.Lock()
+	defer servicesMu.Unlock()
+	if _, dup := services[name]; dup {
+		panic("plugin: ProvideService called twice for " + name)
+	}
+	if services == nil {
+		services = make(map[string]interface{})
+	}
+	services[name] = v
+}
+
+// RequireService stores the service the host provided under name in
+// the value pointed to by ptr. It is meant to be called by plugins,
+// usually from an init function. A plugin should not panic when a
+// service is missing, since after an init function panics no more
+// plugins can be loaded (see PanicError). Instead it can export the
+// error for the host to check once the plugin is open:
+//
+//	var log Logger
+//
+//	// InitErr is why the plugin cannot run, if it cannot.
+//	var InitErr error
+//
+//	func init() {
+//		InitErr = plugin.RequireService("log", &log)
+//	}
+//
+// and in the host:
+//
+//	sym, err := p.Lookup("InitErr")
+//	if err == nil && *sym.(*error) != nil {
+//		err = *sym.(*error)
+//	}
+//
+// It reports an error if no service is provided under name or its
+// type is not assignable to the type ptr points to.
+func RequireService(name string, ptr interface{}) error {
+	pv := reflect.ValueOf(ptr)
+	if pv.Kind() != reflect.Ptr || pv.IsNil() {
+		return errors.New("plugin: RequireService " + name + " needs a non-nil pointer")
+	}
+	servicesMu.Lock()
+	v, ok := services[name]
+	servicesMu.Unlock()
+	if !ok {
+		return errors.New("plugin: service " + name + " not provided by host")
+	}
+	vt, want := reflect.TypeOf(v), pv.Type().Elem()
+	if !vt.AssignableTo(want) {
+		return errors.New("plugin: service " + name + " has type " + vt.String() + ", not assignable to " + want.String())
+	}
+	pv.Elem().Set(reflect.ValueOf(v))
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plugin
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	traceOnce    sync.Once
+	traceEnabled bool
+)
+
+// traceLoad reports whether GODEBUG contains pluginload=1.
+func traceLoad() bool {
+	traceOnce.Do(func() {
+		for s := os.Getenv("GODEBUG"); s != ""; {
+			field := s
+			s = ""
+			for i := 0; i < len(field); i++ {
+				if field[i] == ',' {
+					field, s = field[:i], field[i+1:]
+					break
+				}
+			}
+			if field == "pluginload=1" {
+				traceEnabled = true
+			}
+		}
+	})
+	return traceEnabled
+}
+
+// A loadTrace prints the stages of loading one plugin to standard
+// error. A nil *loadTrace prints nothing.
+type loadTrace struct {
+	name  string
+	start time.Time
+	last  time.Time
+}
+
+func newLoadTrace(name string) *loadTrace {
+	if !traceLoad() {
+		return nil
+	}
+	now := time.Now()
+	return &loadTrace{name: name, start: now, last: now}
+}
+
+// stage reports that stage has completed, with the time taken
+// since the previous stage.
+func (t *loadTrace) stage(stage string) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	os.Stderr.WriteString("pluginload: " + t.name + ": " + stage + " " + now.Sub(t.last).String() + "\n")
+	t.last = now
+}
+
+// done reports the outcome of the load and the total time taken.
+func (t *loadTrace) done(err error) {
+	if t == nil {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = err.Error()
+	}
+	os.Stderr.WriteString("pluginload: " + t.name + ": done " + time.Since(t.start).String() + ": " + result + "\n")
+}
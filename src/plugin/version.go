@@ -0,0 +1,30 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plugin
+
+import "errors"
+
+// LookupVersion searches plugin p for version version of the symbol
+// named symName. A Go identifier cannot contain a suffix such as "@v2",
+// so the plugin exports each version as symName_version:
+//
+//	func Handler_v1(req string) string { ... }
+//	func Handler_v2(ctx context.Context, req string) string { ... }
+func (p *Plugin) LookupVersion(symName, version string) (Symbol, error) {
+	return p.Lookup(symName + "_" + version)
+}
+
+// NegotiateVersion returns the first version in versions that plugin p
+// exports for the symbol named symName, along with the symbol itself.
+// The host lists the versions it supports in order of preference,
+// usually newest first. It reports an error if p exports none of them.
+func (p *Plugin) NegotiateVersion(symName string, versions ...string) (Symbol, string, error) {
+	for _, v := range versions {
+		if s, err := p.LookupVersion(symName, v); err == nil {
+			return s, v, nil
+		}
+	}
+	return nil, "", errors.New("plugin: symbol " + symName + " not found in any supported version in plugin " + p.pluginpath)
+}
@@ -29,7 +29,7 @@ func plugin_lastmoduleinit() (path string, syms map[string]interface{}, errstr s
 	for _, pmd := range activeModules() {
 		if pmd.pluginpath == md.pluginpath {
 			md.bad = true
-			return "", nil, "plugin already loaded"
+			return md.pluginpath, nil, "plugin already loaded"
 		}
 
 		if inRange(pmd.text, pmd.etext, md.text, md.etext) ||
@@ -51,7 +51,7 @@ func plugin_lastmoduleinit() (path string, syms map[string]interface{}, errstr s
 	for _, pkghash := range md.pkghashes {
 		if pkghash.linktimehash != *pkghash.runtimehash {
 			md.bad = true
-			return "", nil, "plugin was built with a different version of package " + pkghash.modulename
+			return md.pluginpath, nil, "plugin was built with a different version of package " + pkghash.modulename + pkghashOwner(pkghash.runtimehash)
 		}
 	}
 
@@ -93,6 +93,22 @@ func plugin_lastmoduleinit() (path string, syms map[string]interface{}, errstr s
 	return md.pluginpath, syms, ""
 }
 
+// pkghashOwner describes, for an error message, the module that
+// holds the package hash at p, which is the module whose copy of
+// the package is in use.
+func pkghashOwner(p *string) string {
+	addr := uintptr(unsafe.Pointer(p))
+	for _, md := range activeModules() {
+		if md.text <= addr && addr < md.end {
+			if md.pluginpath == "" {
+				return " than the program"
+			}
+			return " than plugin " + md.pluginpath
+		}
+	}
+	return ""
+}
+
 func pluginftabverify(md *moduledata) {
 	badtable := false
 	for i := 0; i < len(md.ftab); i++ {